@@ -33,7 +33,7 @@ func doClient() {
 	if flag.NArg() > 0 {
 		command = flag.Arg(0)
 		switch command {
-		case "autocomplete", "exit":
+		case "autocomplete", "invalidate", "accept", "stats", "exit":
 			// these are valid commands
 		case "close":
 			// "close" is an alias for "exit"
@@ -55,9 +55,13 @@ func doClient() {
 		var err error
 		client, err = rpc.Dial(*g_sock, addr)
 		if err != nil {
-			if command == "exit" {
+			if command == "exit" || command == "stats" || command == "accept" {
 				log.Fatal(err)
 			}
+			if command == "invalidate" {
+				// Nothing is cached without a running daemon.
+				return
+			}
 
 			if *g_sock == "unix" {
 				_ = os.Remove(addr)
@@ -77,6 +81,12 @@ func doClient() {
 	switch command {
 	case "autocomplete":
 		cmdAutoComplete(client)
+	case "invalidate":
+		cmdInvalidate(client)
+	case "accept":
+		cmdAccept(client)
+	case "stats":
+		cmdStats(client)
 	case "exit":
 		cmdExit(client)
 	}
@@ -129,6 +139,19 @@ func cmdAutoComplete(c *rpc.Client) {
 	req.Context = &suggest.PackedContext{}
 	req.Builtin = *g_builtin
 	req.IgnoreCase = *g_ignore_case
+	req.Fast = *g_fast
+	// Flags left unset don't override the project's config file.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "builtin":
+			req.Overrides = append(req.Overrides, "Builtin")
+		case "ignore-case":
+			req.Overrides = append(req.Overrides, "IgnoreCase")
+		case "fast":
+			req.Overrides = append(req.Overrides, "Fast")
+		}
+	})
+	req.Mod = *g_mod
 
 	var res AutoCompleteReply
 	var err error
@@ -142,13 +165,74 @@ func cmdAutoComplete(c *rpc.Client) {
 		log.Fatal(err)
 	}
 
-	fmt := suggest.Formatters[*g_format]
+	format := *g_format
+	if format == "csv-header" && *g_no_header {
+		format = "csv-rows"
+	}
+	fmt := suggest.Formatters[format]
 	if fmt == nil {
 		fmt = suggest.NiceFormat
 	}
 	fmt(os.Stdout, res.Candidates, res.Len)
 }
 
+func cmdInvalidate(c *rpc.Client) {
+	var req InvalidateRequest
+	req.ImportPaths = flag.Args()[1:]
+
+	var res InvalidateReply
+	var err error
+	if c == nil {
+		s := Server{}
+		err = s.Invalidate(&req, &res)
+	} else {
+		err = c.Call("Server.Invalidate", &req, &res)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func cmdAccept(c *rpc.Client) {
+	if flag.NArg() != 3 {
+		fmt.Printf("gocode: usage: accept <name> <package>\n")
+		os.Exit(2)
+	}
+	req := AcceptRequest{Name: flag.Arg(1), PkgPath: flag.Arg(2)}
+
+	var res AcceptReply
+	var err error
+	if c == nil {
+		s := Server{}
+		err = s.Accept(&req, &res)
+	} else {
+		err = c.Call("Server.Accept", &req, &res)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func cmdStats(c *rpc.Client) {
+	var req StatsRequest
+	var res StatsReply
+	var err error
+	if c == nil {
+		s := Server{}
+		err = s.Stats(&req, &res)
+	} else {
+		err = c.Call("Server.Stats", &req, &res)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("requests:        %d\n", res.Requests)
+	fmt.Printf("average latency: %v\n", res.AverageLatency)
+	fmt.Printf("cache hit rate:  %.1f%% (%d hits, %d misses)\n", 100*res.HitRate(), res.CacheHits, res.CacheMisses)
+	fmt.Printf("cached packages: %d\n", res.CachedPackages)
+	fmt.Printf("heap in use:     %d KiB\n", res.HeapAlloc/1024)
+}
+
 func cmdExit(c *rpc.Client) {
 	if c == nil {
 		return
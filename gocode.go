@@ -9,7 +9,7 @@ import (
 
 var (
 	g_is_server   = flag.Bool("s", false, "run a server instead of a client")
-	g_format      = flag.String("f", "nice", "output format (vim | emacs | nice | csv | json)")
+	g_format      = flag.String("f", "nice", "output format (vim | emacs | nice | grouped | csv | csv-header | json)")
 	g_input       = flag.String("in", "", "use this file instead of stdin input")
 	g_sock        = flag.String("sock", defaultSocketType, "socket type (unix | tcp | none)")
 	g_addr        = flag.String("addr", "127.0.0.1:37373", "address for tcp socket")
@@ -17,6 +17,9 @@ var (
 	g_source      = flag.Bool("source", false, "use source importer")
 	g_builtin     = flag.Bool("builtin", false, "propose builtin objects")
 	g_ignore_case = flag.Bool("ignore-case", false, "do case-insensitive matching")
+	g_fast        = flag.Bool("fast", false, "skip type checking and propose names only")
+	g_mod         = flag.String("mod", "", "module download mode to use (readonly | vendor | mod), overriding GOFLAGS")
+	g_no_header   = flag.Bool("no-header", false, "omit the header row of the csv-header format")
 )
 
 func getSocketPath() string {
@@ -39,6 +42,9 @@ func usage() {
 	fmt.Fprintf(os.Stderr,
 		"\nCommands:\n"+
 			"  autocomplete [<path>] <offset>     main autocompletion command\n"+
+			"  invalidate [<importpath>...]       drop cached information on packages\n"+
+			"  accept <name> <package>            rank an accepted candidate higher\n"+
+			"  stats                              show daemon cache and latency statistics\n"+
 			"  exit                               terminate the gocode daemon\n")
 }
 
@@ -16,6 +16,12 @@ func Walk(tv *types.TypeAndValue, v Visitor) bool {
 	return true
 }
 
+// WalkVar is like Walk for an addressable variable of type typ, so
+// the methods of both typ and *typ are visited.
+func WalkVar(typ types.Type, v Visitor) {
+	walk(typ, true, true, v)
+}
+
 func walk(typ0 types.Type, addable0, value bool, v Visitor) {
 	// Enumerating valid selector expression identifiers is
 	// surprisingly nuanced.
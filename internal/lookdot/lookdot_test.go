@@ -53,6 +53,10 @@ func (B1) b()
 type B2 struct { b int; B1 }
 
 var loc time.Location
+
+var m map[string]S
+var ss []S
+func fs() S
 `
 
 var tests = []struct {
@@ -89,6 +93,11 @@ var tests = []struct {
 	{"B2", nil},
 
 	{"loc", []string{"String"}},
+
+	// Non-addressable values only have value receiver methods.
+	{"m[\"k\"]", []string{"Sv", "x", "y"}},
+	{"fs()", []string{"Sv", "x", "y"}},
+	{"ss[0]", []string{"Sv", "Sp", "x", "y"}},
 }
 
 func TestWalk(t *testing.T) {
@@ -146,6 +155,32 @@ func TestWalk(t *testing.T) {
 	}
 }
 
+func TestWalkVar(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "src.go", src, 0)
+	if file == nil {
+		t.Fatal(err)
+	}
+
+	cfg := types.Config{
+		Importer: importer.Default(),
+		Error:    func(error) {},
+	}
+	pkg, err := cfg.Check("p", fset, []*ast.File{file}, nil)
+	if pkg == nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	lookdot.WalkVar(pkg.Scope().Lookup("S").Type(), func(obj types.Object) {
+		got = append(got, obj.Name())
+	})
+	sort.Strings(got)
+	if want := []string{"Sp", "Sv", "x", "y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkVar(S): got %v, want %v", got, want)
+	}
+}
+
 func contains(haystack []string, needle string) bool {
 	for _, item := range haystack {
 		if item == needle {
@@ -0,0 +1,79 @@
+package suggest
+
+import (
+	"sort"
+	"sync"
+)
+
+// acceptances records the candidates accepted by the user, for
+// Config.AdaptiveRanking. It is kept in memory only, so it lasts as
+// long as the process, e.g. the daemon.
+var acceptances struct {
+	mu     sync.Mutex
+	seq    int // incremented for each acceptance
+	byName map[acceptanceKey]acceptance
+}
+
+type acceptanceKey struct {
+	name, pkgPath string
+}
+
+type acceptance struct {
+	count int // times accepted
+	last  int // seq of the last acceptance
+}
+
+// RecordAcceptance records that the user accepted the candidate name
+// declared in the package importPath, i.e. Candidate.Name and
+// Candidate.PkgPath, so that it ranks higher in later completions with
+// Config.AdaptiveRanking.
+func RecordAcceptance(name, importPath string) {
+	acceptances.mu.Lock()
+	defer acceptances.mu.Unlock()
+	if acceptances.byName == nil {
+		acceptances.byName = make(map[acceptanceKey]acceptance)
+	}
+	acceptances.seq++
+	key := acceptanceKey{name, importPath}
+	a := acceptances.byName[key]
+	acceptances.byName[key] = acceptance{count: a.count + 1, last: acceptances.seq}
+}
+
+// ResetAcceptances forgets the candidates recorded by RecordAcceptance.
+func ResetAcceptances() {
+	acceptances.mu.Lock()
+	acceptances.byName = nil
+	acceptances.mu.Unlock()
+}
+
+// sortByAcceptance stably moves the accepted candidates first, the most
+// frequently accepted first, then the most recently accepted.
+func sortByAcceptance(res []Candidate) {
+	acceptances.mu.Lock()
+	defer acceptances.mu.Unlock()
+	if len(acceptances.byName) == 0 {
+		return
+	}
+	ranks := make([]acceptance, len(res))
+	for i, c := range res {
+		ranks[i] = acceptances.byName[acceptanceKey{c.Name, c.PkgPath}]
+	}
+	sort.Stable(byAcceptance{res, ranks})
+}
+
+type byAcceptance struct {
+	res   []Candidate
+	ranks []acceptance
+}
+
+func (s byAcceptance) Len() int { return len(s.res) }
+func (s byAcceptance) Swap(i, j int) {
+	s.res[i], s.res[j] = s.res[j], s.res[i]
+	s.ranks[i], s.ranks[j] = s.ranks[j], s.ranks[i]
+}
+func (s byAcceptance) Less(i, j int) bool {
+	if s.ranks[i].count != s.ranks[j].count {
+		return s.ranks[i].count > s.ranks[j].count
+	}
+	return s.ranks[i].last > s.ranks[j].last
+}
@@ -3,9 +3,11 @@ package suggest
 import (
 	"fmt"
 	"go/ast"
+	"go/token"
 	"go/types"
 	"sort"
 	"strings"
+	"unicode"
 )
 
 type Candidate struct {
@@ -13,6 +15,29 @@ type Candidate struct {
 	PkgPath string `json:"package"`
 	Name    string `json:"name"`
 	Type    string `json:"type"`
+
+	// Pos is the declaration position of the candidate. It is only
+	// populated when Config.WithPositions is set.
+	Pos *token.Position `json:"pos,omitempty"`
+
+	// DeclaredIn is the interface declaring an interface method
+	// candidate. It is only populated when Config.WithDeclaredIn is
+	// set.
+	DeclaredIn string `json:"declaredIn,omitempty"`
+
+	// ViaInterface reports that the candidate is selected from a value
+	// of interface type, whose dynamic type may have more members that
+	// are only available after a type assertion.
+	ViaInterface bool `json:"viaInterface,omitempty"`
+
+	// Recv is the receiver of a method candidate, e.g. "(b *Buffer)".
+	// It is only populated when Config.WithSignatures is set.
+	Recv string `json:"recv,omitempty"`
+
+	// Internal reports that the candidate is declared in an internal
+	// package, i.e. one with an "internal" path element, whose API is
+	// not meant to be stable, other than the package being completed.
+	Internal bool `json:"internal,omitempty"`
 }
 
 func (c Candidate) Suggestion() string {
@@ -26,6 +51,17 @@ func (c Candidate) Suggestion() string {
 	}
 }
 
+// Signature returns the full declaration of a function or method
+// candidate, including the receiver if known, e.g.
+// "func (b *Buffer) Write(p []byte) (n int, err error)". For other
+// candidates, it is the same as String.
+func (c Candidate) Signature() string {
+	if c.Class != "func" || c.Recv == "" {
+		return c.String()
+	}
+	return fmt.Sprintf("func %s %s%s", c.Recv, c.Name, strings.TrimPrefix(c.Type, "func"))
+}
+
 func (c Candidate) String() string {
 	if c.Class == "func" {
 		return fmt.Sprintf("%s %s%s", c.Class, c.Name, strings.TrimPrefix(c.Type, "func"))
@@ -33,18 +69,57 @@ func (c Candidate) String() string {
 	return fmt.Sprintf("%s %s %s", c.Class, c.Name, c.Type)
 }
 
-type candidatesByClassAndName []Candidate
+// CandidateGroup is a section of candidates of the same class.
+type CandidateGroup struct {
+	Class      string      `json:"class"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+// GroupByClass partitions candidates by class. Groups are ordered by
+// the first candidate of each class, and candidates keep their order
+// within a group.
+func GroupByClass(candidates []Candidate) []CandidateGroup {
+	var groups []CandidateGroup
+	index := make(map[string]int)
+	for _, c := range candidates {
+		i, ok := index[c.Class]
+		if !ok {
+			i = len(groups)
+			index[c.Class] = i
+			groups = append(groups, CandidateGroup{Class: c.Class})
+		}
+		groups[i].Candidates = append(groups[i].Candidates, c)
+	}
+	return groups
+}
+
+// candidatesByClassAndName sorts candidates by class and name. Within a
+// class, candidates with a higher bonus come first.
+type candidatesByClassAndName struct {
+	res   []Candidate
+	bonus []int
+}
 
-func (s candidatesByClassAndName) Len() int      { return len(s) }
-func (s candidatesByClassAndName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s candidatesByClassAndName) Len() int { return len(s.res) }
+func (s candidatesByClassAndName) Swap(i, j int) {
+	s.res[i], s.res[j] = s.res[j], s.res[i]
+	s.bonus[i], s.bonus[j] = s.bonus[j], s.bonus[i]
+}
 
 func (s candidatesByClassAndName) Less(i, j int) bool {
-	if s[i].Class != s[j].Class {
-		return s[i].Class < s[j].Class
+	if s.res[i].Class != s.res[j].Class {
+		return s.res[i].Class < s.res[j].Class
+	}
+	if s.bonus[i] != s.bonus[j] {
+		return s.bonus[i] > s.bonus[j]
 	}
-	return s[i].Name < s[j].Name
+	return s.res[i].Name < s.res[j].Name
 }
 
+// prefixBonus ranks the candidates matching the partial identifier by
+// prefix before those matching by initials.
+const prefixBonus = 1
+
 type objectFilter func(types.Object) bool
 
 var objectFilters = map[string]objectFilter{
@@ -55,6 +130,99 @@ var objectFilters = map[string]objectFilter{
 	"var":     func(obj types.Object) bool { _, ok := obj.(*types.Var); return ok },
 }
 
+func isTypeOrPackage(obj types.Object) bool {
+	switch obj.(type) {
+	case *types.TypeName, *types.PkgName:
+		return true
+	}
+	return false
+}
+
+func isInterface(obj types.Object) bool {
+	if _, ok := obj.(*types.TypeName); !ok || obj.Type() == nil {
+		return false
+	}
+	return types.IsInterface(obj.Type())
+}
+
+func isInterfaceOrPackage(obj types.Object) bool {
+	_, ok := obj.(*types.PkgName)
+	return ok || isInterface(obj)
+}
+
+func isChan(obj types.Object) bool {
+	if _, ok := obj.(*types.Var); !ok {
+		return false
+	}
+	_, ok := obj.Type().Underlying().(*types.Chan)
+	return ok
+}
+
+// constantsOf returns a filter accepting the constants of the named
+// type typ, or nil if typ is not a named type.
+func constantsOf(typ types.Type) objectFilter {
+	if _, ok := typ.(*types.Named); !ok {
+		return nil
+	}
+	return func(obj types.Object) bool {
+		_, ok := obj.(*types.Const)
+		return ok && types.Identical(obj.Type(), typ)
+	}
+}
+
+// assignableTo returns a filter accepting the values assignable to typ.
+func assignableTo(typ types.Type) objectFilter {
+	return func(obj types.Object) bool {
+		switch obj.(type) {
+		case *types.Const, *types.Var, *types.Nil:
+			return types.AssignableTo(obj.Type(), typ)
+		}
+		return false
+	}
+}
+
+// elementsOf returns a filter accepting the values that can be listed
+// in a composite literal of type typ, i.e. its elements or, for maps,
+// its keys. It returns nil for other types.
+func elementsOf(typ types.Type) objectFilter {
+	switch t := typ.Underlying().(type) {
+	case *types.Slice:
+		return assignableTo(t.Elem())
+	case *types.Array:
+		return assignableTo(t.Elem())
+	case *types.Map:
+		return assignableTo(t.Key())
+	}
+	return nil
+}
+
+// elementType returns the type of the elements of a composite literal
+// of type typ whose own type may be elided, i.e. its elements or, for
+// maps, its values if keyed is set and its keys otherwise. A pointer
+// element type is dereferenced, as &T{...} may be elided to {...}. It
+// returns nil for other types.
+func elementType(typ types.Type, keyed bool) types.Type {
+	var elem types.Type
+	switch t := typ.Underlying().(type) {
+	case *types.Slice:
+		elem = t.Elem()
+	case *types.Array:
+		elem = t.Elem()
+	case *types.Map:
+		if keyed {
+			elem = t.Elem()
+		} else {
+			elem = t.Key()
+		}
+	default:
+		return nil
+	}
+	if ptr, ok := elem.(*types.Pointer); ok {
+		elem = ptr.Elem()
+	}
+	return elem
+}
+
 func classifyObject(obj types.Object) string {
 	switch obj.(type) {
 	case *types.Builtin:
@@ -63,6 +231,8 @@ func classifyObject(obj types.Object) string {
 		return "const"
 	case *types.Func:
 		return "func"
+	case *types.Label:
+		return "label"
 	case *types.Nil:
 		return "const"
 	case *types.PkgName:
@@ -78,12 +248,27 @@ func classifyObject(obj types.Object) string {
 type candidateCollector struct {
 	exact      []types.Object
 	badcase    []types.Object
+	byInitials []types.Object
 	imports    []*ast.ImportSpec
 	localpkg   *types.Package
+	fset       *token.FileSet
 	partial    string
 	filter     objectFilter
+	allow      objectFilter
+	prefer     objectFilter
 	builtin    bool
 	ignoreCase bool
+	initials   bool
+	declaredIn bool
+	keepOrder  bool
+	adaptive   bool
+	recv       bool
+	visibility Visibility
+	// packages, if non-nil, reports whether candidates from the
+	// package with the given path are allowed.
+	packages func(path string) bool
+	// viaInterface is set when selecting from an interface value.
+	viaInterface bool
 }
 
 func (b *candidateCollector) getCandidates() []Candidate {
@@ -92,12 +277,34 @@ func (b *candidateCollector) getCandidates() []Candidate {
 		objs = b.badcase
 	}
 
-	var res []Candidate
+	// Candidates matching the prefer filter come first. Unless
+	// keepOrder is set, both groups are sorted by class and name, prefix
+	// matches before matches by initials within a class, and with
+	// adaptive, accepted candidates come first.
+	var preferred, rest candidatesByClassAndName
+	add := func(obj types.Object, bonus int) {
+		group := &rest
+		if b.prefer != nil && b.prefer(obj) {
+			group = &preferred
+		}
+		group.res = append(group.res, b.asCandidate(obj))
+		group.bonus = append(group.bonus, bonus)
+	}
 	for _, obj := range objs {
-		res = append(res, b.asCandidate(obj))
+		add(obj, prefixBonus)
+	}
+	for _, obj := range b.byInitials {
+		add(obj, 0)
 	}
-	sort.Sort(candidatesByClassAndName(res))
-	return res
+	if !b.keepOrder {
+		sort.Sort(preferred)
+		sort.Sort(rest)
+		if b.adaptive {
+			sortByAcceptance(preferred.res)
+			sortByAcceptance(rest.res)
+		}
+	}
+	return append(preferred.res, rest.res...)
 }
 
 func (b *candidateCollector) asCandidate(obj types.Object) Candidate {
@@ -107,7 +314,10 @@ func (b *candidateCollector) asCandidate(obj types.Object) Candidate {
 	case "const", "func", "var":
 		typ = obj.Type()
 	case "type":
-		typ = obj.Type().Underlying()
+		// Types are not resolved in fast mode.
+		if t := obj.Type(); t != nil {
+			typ = t.Underlying()
+		}
 	}
 
 	var typStr string
@@ -119,6 +329,10 @@ func (b *candidateCollector) asCandidate(obj types.Object) Candidate {
 	default:
 		if _, isBuiltin := obj.(*types.Builtin); isBuiltin {
 			typStr = builtinTypes[obj.Name()]
+			if typStr == "" {
+				// A builtin added by a newer toolchain.
+				typStr = "func(...)"
+			}
 		} else if t != nil {
 			typStr = types.TypeString(t, b.qualify)
 		}
@@ -129,18 +343,86 @@ func (b *candidateCollector) asCandidate(obj types.Object) Candidate {
 		path = pkg.Path()
 	}
 
+	var pos *token.Position
+	if b.fset != nil && obj.Pos().IsValid() {
+		p := b.fset.Position(obj.Pos())
+		pos = &p
+	}
+
+	var declaredIn string
+	if b.declaredIn {
+		declaredIn = b.declaringInterface(obj)
+	}
+
+	var recv string
+	if b.recv {
+		recv = b.receiver(obj)
+	}
+
 	return Candidate{
-		Class:   objClass,
-		PkgPath: path,
-		Name:    obj.Name(),
-		Type:    typStr,
+		Class:        objClass,
+		PkgPath:      path,
+		Name:         obj.Name(),
+		Type:         typStr,
+		Pos:          pos,
+		DeclaredIn:   declaredIn,
+		ViaInterface: b.viaInterface,
+		Recv:         recv,
+		Internal:     (b.localpkg == nil || path != b.localpkg.Path()) && isInternalPath(path),
+	}
+}
+
+func isInternalPath(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "internal" {
+			return true
+		}
+	}
+	return false
+}
+
+// receiver returns the parenthesized receiver of obj if it is a
+// method, and "" otherwise.
+func (b *candidateCollector) receiver(obj types.Object) string {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return ""
+	}
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok || sig.Recv() == nil {
+		return ""
+	}
+	typ := types.TypeString(sig.Recv().Type(), b.qualify)
+	if name := sig.Recv().Name(); name != "" && name != "_" {
+		return "(" + name + " " + typ + ")"
+	}
+	return "(" + typ + ")"
+}
+
+// declaringInterface returns the interface type that declares obj if
+// it is an interface method, and "" otherwise. Methods promoted from
+// an embedded interface keep the receiver of the embedded interface.
+func (b *candidateCollector) declaringInterface(obj types.Object) string {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return ""
+	}
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok || sig.Recv() == nil {
+		return ""
+	}
+	recv := sig.Recv().Type()
+	if !types.IsInterface(recv) {
+		return ""
 	}
+	return types.TypeString(recv, b.qualify)
 }
 
 var builtinTypes = map[string]string{
 	// Universe.
 	"append":  "func(slice []Type, elems ..Type) []Type",
 	"cap":     "func(v Type) int",
+	"clear":   "func(t Type)",
 	"close":   "func(c chan<- Type)",
 	"complex": "func(real FloatType, imag FloatType) ComplexType",
 	"copy":    "func(dst []Type, src []Type) int",
@@ -148,6 +430,8 @@ var builtinTypes = map[string]string{
 	"imag":    "func(c ComplexType) FloatType",
 	"len":     "func(v Type) int",
 	"make":    "func(Type, size IntegerType) Type",
+	"max":     "func(x Type, y ...Type) Type",
+	"min":     "func(x Type, y ...Type) Type",
 	"new":     "func(Type) *Type",
 	"panic":   "func(v interface{})",
 	"print":   "func(args ...Type)",
@@ -189,6 +473,11 @@ func (b *candidateCollector) qualify(pkg *types.Package) string {
 }
 
 func (b *candidateCollector) appendObject(obj types.Object) {
+	// Blank identifiers (e.g. struct padding fields) can't be referred to.
+	if obj.Name() == "_" {
+		return
+	}
+
 	if obj.Pkg() != b.localpkg {
 		if obj.Parent() == types.Universe {
 			if !b.builtin {
@@ -196,6 +485,25 @@ func (b *candidateCollector) appendObject(obj types.Object) {
 			}
 		} else if !obj.Exported() {
 			return
+		} else if b.packages != nil && obj.Pkg() != nil && !b.packages(obj.Pkg().Path()) {
+			return
+		}
+	}
+
+	if b.allow != nil && !b.allow(obj) {
+		return
+	}
+
+	if _, isPkg := obj.(*types.PkgName); !isPkg && obj.Parent() != types.Universe {
+		switch b.visibility {
+		case VisibilityExported:
+			if !obj.Exported() {
+				return
+			}
+		case VisibilityUnexported:
+			if obj.Exported() {
+				return
+			}
 		}
 	}
 
@@ -207,5 +515,40 @@ func (b *candidateCollector) appendObject(obj types.Object) {
 		b.exact = append(b.exact, obj)
 	} else if strings.HasPrefix(strings.ToLower(obj.Name()), strings.ToLower(b.partial)) {
 		b.badcase = append(b.badcase, obj)
+	} else if b.initials && matchInitials(obj.Name(), b.partial) {
+		b.byInitials = append(b.byInitials, obj)
+	}
+}
+
+// matchInitials reports whether partial, which must be at least two
+// characters long, is a case-insensitive prefix of the initials of the
+// words of name. Words start at the beginning of name, after an
+// underscore, at a lower to upper case transition, and at the last
+// upper case letter of an acronym followed by a lower case letter.
+// For example, "uc" matches "userCount", "UserController" and
+// "user_cache", and "hs" matches "HTTPServer".
+func matchInitials(name, partial string) bool {
+	if len(partial) < 2 {
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(wordInitials(name)), strings.ToLower(partial))
+}
+
+func wordInitials(name string) string {
+	var initials []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		if r == '_' {
+			continue
+		}
+		switch {
+		case i == 0, runes[i-1] == '_':
+		case unicode.IsUpper(r) && unicode.IsLower(runes[i-1]):
+		case unicode.IsUpper(r) && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+		default:
+			continue
+		}
+		initials = append(initials, r)
 	}
+	return string(initials)
 }
@@ -0,0 +1,83 @@
+package suggest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ConfigFileName is the name of the file holding project-wide defaults
+// for Config, encoded as JSON. For example:
+//
+//	{"Builtin": true, "IgnoreCase": true}
+const ConfigFileName = ".gocode"
+
+// configFiles caches the decoded config files by path, so that a file
+// is only read again once it is modified.
+var configFiles = struct {
+	sync.Mutex
+	entries map[string]configFile
+}{entries: make(map[string]configFile)}
+
+type configFile struct {
+	modTime time.Time
+	config  Config
+}
+
+// LoadConfigFile looks for a ConfigFileName file in dir and its parent
+// directories and returns the defaults in the nearest one, along with
+// its path. It returns the zero Config and "" if there is none. Callers
+// apply their own settings over the defaults.
+func LoadConfigFile(dir string) (Config, string, error) {
+	for {
+		path := filepath.Join(dir, ConfigFileName)
+		info, err := os.Stat(path)
+		if err == nil {
+			cfg, err := loadConfigFile(path, info.ModTime())
+			return cfg, path, err
+		}
+		if !os.IsNotExist(err) {
+			return Config{}, path, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return Config{}, "", nil
+		}
+		dir = parent
+	}
+}
+
+// loadConfigFile decodes the config file path, last modified at
+// modTime, unless it is cached.
+func loadConfigFile(path string, modTime time.Time) (Config, error) {
+	configFiles.Lock()
+	cached, ok := configFiles.entries[path]
+	configFiles.Unlock()
+	if ok && cached.modTime.Equal(modTime) {
+		return cached.config, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+	var cfg Config
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %v", path, err)
+	}
+	configFiles.Lock()
+	configFiles.entries[path] = configFile{modTime: modTime, config: cfg}
+	configFiles.Unlock()
+	return cfg, nil
+}
+
+// resetConfigFiles drops the decoded config files.
+func resetConfigFiles() {
+	configFiles.Lock()
+	configFiles.entries = make(map[string]configFile)
+	configFiles.Unlock()
+}
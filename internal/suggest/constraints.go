@@ -0,0 +1,60 @@
+package suggest
+
+import (
+	"bytes"
+	"go/build"
+	"sort"
+	"strings"
+)
+
+// buildConstraintPartial reports whether the cursor is in the expression
+// of a "//go:build" or "// +build" line. The scanner discards comments,
+// so the line is inspected as is. It returns the part of the tag before
+// the cursor.
+func buildConstraintPartial(file []byte, cursor int) (string, bool) {
+	start := bytes.LastIndexByte(file[:cursor], '\n') + 1
+	line := strings.TrimLeft(string(file[start:cursor]), " \t")
+	var expr string
+	switch {
+	case strings.HasPrefix(line, "//go:build "):
+		expr = strings.TrimPrefix(line, "//go:build ")
+	case strings.HasPrefix(line, "// +build "):
+		expr = strings.TrimPrefix(line, "// +build ")
+	default:
+		return "", false
+	}
+	i := len(expr)
+	for i > 0 && isTagChar(expr[i-1]) {
+		i--
+	}
+	return expr[i:], true
+}
+
+func isTagChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '.'
+}
+
+// buildConstraintCandidates returns the build tags starting with prefix
+// as "tag" candidates, whose type tells the kind of tag. Operators are
+// only offered between tags, i.e. for an empty prefix.
+func buildConstraintCandidates(prefix string) []Candidate {
+	var res []Candidate
+	add := func(typ string, names ...string) {
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				res = append(res, Candidate{Class: "tag", Name: name, Type: typ})
+			}
+		}
+	}
+	add("GOOS", strings.Fields(GoosList)...)
+	add("GOOS", "unix")
+	add("GOARCH", strings.Fields(GoarchList)...)
+	add("compiler", "gc", "gccgo")
+	add("feature", "cgo", "ignore")
+	add("release", build.Default.ReleaseTags...)
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	if prefix == "" {
+		add("operator", "!", "&&", "||", "(", ")")
+	}
+	return res
+}
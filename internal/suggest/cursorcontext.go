@@ -4,6 +4,11 @@ import (
 	"bytes"
 	"go/scanner"
 	"go/token"
+	"hash/maphash"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
 )
 
 type tokenIterator struct {
@@ -14,6 +19,7 @@ type tokenIterator struct {
 type tokenItem struct {
 	tok token.Token
 	lit string
+	off int
 }
 
 func (i tokenItem) String() string {
@@ -23,30 +29,147 @@ func (i tokenItem) String() string {
 	return i.tok.String()
 }
 
-func newTokenIterator(src []byte, cursor int) (tokenIterator, int) {
-	fset := token.NewFileSet()
-	file := fset.AddFile("", fset.Base(), len(src))
-	cursorPos := file.Pos(cursor)
-
-	var s scanner.Scanner
-	s.Init(file, src, nil, 0)
-	tokens := make([]tokenItem, 0, 1000)
-	lastPos := token.NoPos
-	for {
-		pos, tok, lit := s.Scan()
-		if tok == token.EOF || pos >= cursorPos {
-			break
-		}
-		tokens = append(tokens, tokenItem{
-			tok: tok,
-			lit: lit,
-		})
-		lastPos = pos
+func newTokenIterator(tokens []tokenItem, cursor int) (tokenIterator, int) {
+	lastOff := -1
+	if len(tokens) > 0 {
+		lastOff = tokens[len(tokens)-1].off
 	}
 	return tokenIterator{
 		tokens: tokens,
 		pos:    len(tokens) - 1,
-	}, int(cursorPos - lastPos)
+	}, cursor - lastOff
+}
+
+// maxTokenCacheEntries is the number of buffers whose tokens are cached.
+const maxTokenCacheEntries = 32
+
+// tokenCache holds the tokens of the buffers last scanned by filename,
+// so that completing repeatedly in a large buffer only rescans it from
+// around the first change rather than from the top. Entries are never
+// modified once stored, so they are used without holding the lock.
+var tokenCache = struct {
+	sync.Mutex
+	seed    maphash.Seed
+	entries map[string]*tokenCacheEntry
+}{
+	seed:    maphash.MakeSeed(),
+	entries: make(map[string]*tokenCacheEntry),
+}
+
+// tokenCacheEntry holds the tokens of src that start before end.
+type tokenCacheEntry struct {
+	hash   uint64
+	src    []byte
+	end    int
+	tokens []tokenItem
+}
+
+// resetTokenCache drops the tokens of all buffers.
+func resetTokenCache() {
+	tokenCache.Lock()
+	tokenCache.entries = make(map[string]*tokenCacheEntry)
+	tokenCache.Unlock()
+}
+
+// scanTokens returns the tokens of src, the buffer of filename, that
+// start before cursor, along with how many of them were reused from the
+// token cache.
+func scanTokens(filename string, src []byte, cursor int) ([]tokenItem, int) {
+	var h maphash.Hash
+	h.SetSeed(tokenCache.seed)
+	h.Write(src)
+	hash := h.Sum64()
+
+	tokenCache.Lock()
+	prev := tokenCache.entries[filename]
+	tokenCache.Unlock()
+
+	var cached []tokenItem
+	changed := 0
+	if prev != nil {
+		cached = prev.tokens
+		// The hash only rules out most changed buffers quickly.
+		if prev.hash == hash && cursor <= prev.end && bytes.Equal(prev.src, src) {
+			n := sort.Search(len(cached), func(i int) bool { return cached[i].off >= cursor })
+			return cached[:n:n], n
+		}
+		changed = commonPrefixLen(prev.src, src)
+	}
+
+	// A cached token is unaffected by changes to the source if the
+	// token following it starts before the first changed byte. The
+	// scanner can only be restarted right after a semicolon though,
+	// where it doesn't need to know about the preceding tokens to
+	// insert the next automatic semicolon.
+	last := sort.Search(len(cached), func(i int) bool { return cached[i].off >= changed }) - 2
+	for last >= 0 && cached[last].tok != token.SEMICOLON {
+		last--
+	}
+	if last < 0 {
+		last = -1
+	}
+	start := 0
+	if last >= 0 {
+		start = cached[last+1].off
+	}
+	// The cached tokens are shared, so appending must not overwrite
+	// those following them.
+	tokens := cached[: last+1 : last+1]
+
+	if start < cursor {
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(src)-start)
+
+		var s scanner.Scanner
+		s.Init(file, src[start:], nil, 0)
+		for {
+			pos, tok, lit := s.Scan()
+			off := start + file.Offset(pos)
+			if tok == token.EOF || off >= cursor {
+				break
+			}
+			tokens = append(tokens, tokenItem{
+				tok: tok,
+				lit: lit,
+				off: off,
+			})
+		}
+	} else {
+		for len(tokens) > 0 && tokens[len(tokens)-1].off >= cursor {
+			tokens = tokens[:len(tokens)-1]
+		}
+	}
+
+	entry := &tokenCacheEntry{
+		hash:   hash,
+		src:    append([]byte(nil), src...),
+		end:    cursor,
+		tokens: tokens,
+	}
+	tokenCache.Lock()
+	if _, ok := tokenCache.entries[filename]; !ok && len(tokenCache.entries) >= maxTokenCacheEntries {
+		for name := range tokenCache.entries {
+			delete(tokenCache.entries, name)
+			break
+		}
+	}
+	tokenCache.entries[filename] = entry
+	tokenCache.Unlock()
+	return tokens, last + 1
+}
+
+// commonPrefixLen returns the length of the common prefix of a and b.
+func commonPrefixLen(a, b []byte) int {
+	// Compare blocks first, which bytes.Equal does efficiently.
+	const block = 64
+	n := 0
+	for n+block <= len(a) && n+block <= len(b) && bytes.Equal(a[n:n+block], b[n:n+block]) {
+		n += block
+	}
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
 }
 
 func (ti *tokenIterator) token() tokenItem {
@@ -146,6 +269,14 @@ func (ti *tokenIterator) extractLiteralType() (res string) {
 		}
 	}
 
+	// The element type may be a pointer, e.g. "[]*T".
+	if ti.token().tok == token.MUL {
+		if pi := *ti; !pi.prev() || pi.token().tok != token.RBRACK {
+			return ""
+		}
+		ti.prev()
+	}
+
 	// Continuing backwards, we might see "[]", "[...]", "[expr]",
 	// or "map[T]".
 	for ti.token().tok == token.RBRACK {
@@ -190,18 +321,38 @@ func (ti *tokenIterator) extractLiteralType() (res string) {
 // Of course there are also slightly more complicated rules for brackets:
 //   ident{}.ident()[5][4](), etc.
 func (ti *tokenIterator) extractExpr() string {
+	first, end := ti.skipExpr()
+	return joinTokens(ti.tokens[first:end])
+}
+
+// skipExpr moves the cursor back over the expression extracted by
+// extractExpr, whose tokens are ti.tokens[first:end].
+func (ti *tokenIterator) skipExpr() (first, end int) {
 	orig := ti.pos
+	end = orig
 
 	// Contains the type of the previously scanned token (initialized with
 	// the token right under the cursor). This is the token to the *right* of
-	// the current one.
-	prev := ti.token().tok
+	// the current one. The token under the cursor is a '.', or a binary
+	// operator whose left operand ends the same way a selector base does.
+	prev := token.PERIOD
 loop:
 	for {
 		if !ti.prev() {
-			return joinTokens(ti.tokens[:orig])
+			return 0, end
 		}
 		switch ti.token().tok {
+		case token.SEMICOLON:
+			// A '.' at the start of a line is preceded by an automatic
+			// semicolon. It doesn't compile, but is likely to be joined
+			// with the previous line once completed:
+			//   a
+			//   	.#
+			if ti.pos+1 != orig || ti.tokens[orig].tok != token.PERIOD || ti.token().lit != "\n" {
+				break loop
+			}
+			end = ti.pos
+			continue
 		case token.PERIOD:
 			// If the '.' is not followed by IDENT, it's invalid.
 			if prev != token.IDENT {
@@ -218,8 +369,9 @@ loop:
 		case token.RBRACE:
 			// This one can only be a part of type initialization, like:
 			//   Dummy{}.Hello()
+			//   [3]Point{}[0].X
 			// It is valid Go if Hello method is defined on a non-pointer receiver.
-			if prev != token.PERIOD {
+			if prev != token.PERIOD && prev != token.LBRACK {
 				break loop
 			}
 			ti.skipToBalancedPair()
@@ -229,6 +381,29 @@ loop:
 			switch prev {
 			case token.PERIOD, token.LBRACK, token.LPAREN:
 				// all ok
+			case token.LBRACE:
+				// An instantiated generic type can be initialized, like:
+				//   Repo[User]{}.All()
+				if ti.token().tok != token.RBRACK {
+					break loop
+				}
+			case token.IDENT:
+				// So can an array, slice or map type, like:
+				//   [3]Point{}[0].X
+				//   []geom.Point{}[0].X
+				next := ti.pos + 2
+				if next+2 < len(ti.tokens) && ti.tokens[next].tok == token.PERIOD && ti.tokens[next+1].tok == token.IDENT {
+					next += 2
+				}
+				if ti.token().tok != token.RBRACK || next >= len(ti.tokens) || ti.tokens[next].tok != token.LBRACE {
+					break loop
+				}
+				ti.skipToBalancedPair()
+				if mi := *ti; mi.prev() && mi.token().tok == token.MAP {
+					ti.prev()
+				}
+				prev = ti.token().tok
+				continue
 			default:
 				break loop
 			}
@@ -238,14 +413,525 @@ loop:
 		}
 		prev = ti.token().tok
 	}
-	return joinTokens(ti.tokens[ti.pos+1 : orig])
+	return ti.pos + 1, end
+}
+
+// Move the cursor back over the results of a return statement to the
+// 'return' keyword, e.g. from the last ',' in:
+//   return a, f(b, c), #
+// It returns the number of results skipped, or -1 if the cursor is not
+// in the results of a return statement.
+func (ti *tokenIterator) skipToReturn() int {
+	n := 0
+	for {
+		switch ti.token().tok {
+		case token.RETURN:
+			return n
+		case token.COMMA:
+			n++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if !ti.skipToBalancedPair() {
+				return -1
+			}
+		case token.LPAREN, token.LBRACK, token.LBRACE, token.SEMICOLON:
+			return -1
+		}
+		if !ti.prev() {
+			return -1
+		}
+	}
+}
+
+// Move the cursor back over the preceding type arguments of a generic
+// instantiation to its '[', e.g. from the ',' in:
+//   NewCache[string, #]
+// It returns the number of type arguments skipped, or -1 if the cursor
+// is not in a type argument list following an identifier. The first
+// type argument can't be told apart from an index expression, so at
+// least one argument must precede the cursor.
+func (ti *tokenIterator) skipToTypeArgs() int {
+	n := 0
+	for {
+		switch ti.token().tok {
+		case token.LBRACK:
+			if n == 0 || ti.pos == 0 || ti.tokens[ti.pos-1].tok != token.IDENT {
+				return -1
+			}
+			return n
+		case token.COMMA:
+			n++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if !ti.skipToBalancedPair() {
+				return -1
+			}
+		case token.LPAREN, token.LBRACE, token.SEMICOLON:
+			return -1
+		}
+		if !ti.prev() {
+			return -1
+		}
+	}
+}
+
+// Check whether the '[' under the cursor opens the type arguments of
+// the generic type of a type declaration, e.g.:
+//   type IntList = List[#
+//   type IntTree pkg.Tree[#
+// Unlike in expressions, the first type argument can't be an index.
+func (ti *tokenIterator) isTypeDeclArgs() bool {
+	// The generic type, possibly qualified.
+	if !ti.prev() || ti.token().tok != token.IDENT || !ti.prev() {
+		return false
+	}
+	if ti.token().tok == token.PERIOD {
+		if !ti.prev() || ti.token().tok != token.IDENT || !ti.prev() {
+			return false
+		}
+	}
+	if ti.token().tok == token.ASSIGN && !ti.prev() {
+		return false
+	}
+
+	// The declared type, either right after 'type' or in a group.
+	if ti.token().tok != token.IDENT || !ti.prev() {
+		return false
+	}
+	switch ti.token().tok {
+	case token.TYPE:
+		return true
+	case token.LPAREN, token.SEMICOLON:
+		return ti.skipToLeft(token.LPAREN, token.RPAREN) && ti.prev() && ti.token().tok == token.TYPE
+	}
+	return false
+}
+
+// newContextIterator returns an iterator positioned at the token right
+// before the partial identifier under the cursor, or right before the
+// cursor if there is no partial identifier. It returns false if there
+// is no such token, or if the cursor follows an identifier separated
+// from it by white space.
+func newContextIterator(tokens []tokenItem, cursor int) (tokenIterator, bool) {
+	iter, off := newTokenIterator(tokens, cursor)
+	if len(iter.tokens) == 0 {
+		return iter, false
+	}
+	if tok := iter.token(); tok.tok.IsKeyword() || tok.tok == token.IDENT {
+		if off > len(tok.String()) {
+			return iter, tok.tok != token.IDENT
+		}
+		return iter, iter.prev()
+	}
+	return iter, true
+}
+
+// selectorBase returns the operand of the selector expression under the
+// cursor as written, e.g. "a.b" for "a.b.C#", along with its offset. It
+// returns -1 if the cursor is not in a selector expression.
+func (dc *deducedContext) selectorBase() (string, int) {
+	iter, ok := newContextIterator(dc.tokens, dc.cursor)
+	if !ok || iter.token().tok != token.PERIOD {
+		return "", -1
+	}
+	dot := iter.token().off
+	first, end := iter.skipExpr()
+	if first >= end {
+		return "", -1
+	}
+	start := iter.tokens[first].off
+	return strings.TrimSpace(string(dc.data[start:dot])), start
+}
+
+// keyedElement reports whether the composite literal under the cursor,
+// whose type is elided, follows a key, e.g. is a map value rather than
+// a map key.
+func (dc *deducedContext) keyedElement() bool {
+	iter, ok := newContextIterator(dc.tokens, dc.cursor)
+	if !ok || !iter.isElidedLiteral() {
+		return false
+	}
+	return iter.token().tok == token.COLON
+}
+
+// returnIndex returns the index of the result of the return statement
+// under the cursor, or -1 if the cursor is not in a return statement.
+func (dc *deducedContext) returnIndex() int {
+	iter, ok := newContextIterator(dc.tokens, dc.cursor)
+	if !ok {
+		return -1
+	}
+	return iter.skipToReturn()
+}
+
+// statementStart reports whether the cursor is at the beginning of a
+// statement, i.e. the preceding token ends a statement or opens a block.
+func (dc *deducedContext) statementStart() bool {
+	iter, ok := newContextIterator(dc.tokens, dc.cursor)
+	if !ok {
+		return false
+	}
+	switch iter.token().tok {
+	case token.LBRACE, token.RBRACE, token.SEMICOLON:
+		return true
+	}
+	return false
+}
+
+// Check whether the cursor at offset off into the string literal lit is
+// inside the literal, i.e. not past its closing quote.
+func insideLiteral(lit string, off int) bool {
+	if off < len(lit) {
+		return true
+	}
+	// Unterminated literals extend to the cursor.
+	return len(lit) < 2 || lit[len(lit)-1] != lit[0]
+}
+
+// Starting from the string literal under the cursor, check whether it
+// is the tag of a field in a struct type, i.e. it follows the type of
+// the field and the enclosing braces are those of a struct.
+func (ti *tokenIterator) isStructTag() bool {
+	if !ti.prev() {
+		return false
+	}
+	switch ti.token().tok {
+	case token.IDENT, token.RBRACE, token.RPAREN, token.RBRACK:
+	default:
+		return false
+	}
+	return ti.skipToLeftCurly() && ti.prev() && ti.token().tok == token.STRUCT
+}
+
+// Starting from the string literal under the cursor, check whether it
+// is the first string argument of a printf-like call, e.g.:
+//   fmt.Errorf("...#", err)
+//   fmt.Fprintf(w, "...#")
+// and return the name of the called function, or "" if it isn't. See
+// isPrintfFunc for the functions that are printf-like.
+func (ti *tokenIterator) extractFormatFunc(extra []string) string {
+loop:
+	for ti.prev() {
+		switch ti.token().tok {
+		case token.LPAREN:
+			break loop
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			ti.skipToBalancedPair()
+		case token.STRING, token.LBRACK, token.LBRACE, token.SEMICOLON:
+			return ""
+		}
+	}
+	if ti.token().tok != token.LPAREN || !ti.prev() || ti.token().tok != token.IDENT {
+		return ""
+	}
+	name := ti.token().lit
+	if ti.prev() && ti.token().tok == token.PERIOD && ti.prev() && ti.token().tok == token.IDENT {
+		name = ti.token().lit + "." + name
+	}
+	if !isPrintfFunc(name, extra) {
+		return ""
+	}
+	return name
+}
+
+// knownPrintfFuncs holds the names of the printf-like functions and methods
+// of the fmt, log and testing packages.
+var knownPrintfFuncs = map[string]bool{
+	"Appendf": true,
+	"Errorf":  true,
+	"Fatalf":  true,
+	"Fprintf": true,
+	"Logf":    true,
+	"Panicf":  true,
+	"Printf":  true,
+	"Skipf":   true,
+	"Sprintf": true,
+}
+
+// isPrintfFunc reports whether a call of name, possibly qualified as in
+// "log.Printf", is printf-like. Besides knownPrintfFuncs, which match whatever
+// the qualifier, it is if name or its unqualified part is in extra, as
+// described for Config.PrintfFuncs.
+func isPrintfFunc(name string, extra []string) bool {
+	base := name[strings.LastIndexByte(name, '.')+1:]
+	for _, fn := range extra {
+		if fn == name || fn == base {
+			return true
+		}
+	}
+	return knownPrintfFuncs[base]
+}
+
+// Check whether the string literal under the cursor is the path of an
+// import spec, e.g.:
+//   import "encoding/js#"
+//   import (
+//   	"fmt"
+//   	j "encoding/js#"
+//   )
+func (ti *tokenIterator) isImportPath() bool {
+	if !ti.prev() {
+		return false
+	}
+	if tok := ti.token().tok; tok == token.IDENT || tok == token.PERIOD {
+		// named or dot import
+		if !ti.prev() {
+			return false
+		}
+	}
+	switch ti.token().tok {
+	case token.IMPORT:
+		return true
+	case token.LPAREN:
+		return ti.prev() && ti.token().tok == token.IMPORT
+	case token.SEMICOLON:
+	default:
+		return false
+	}
+	// Skip the preceding specs of the import block.
+	for ti.prev() {
+		switch ti.token().tok {
+		case token.STRING, token.IDENT, token.PERIOD, token.SEMICOLON:
+		case token.LPAREN:
+			return ti.prev() && ti.token().tok == token.IMPORT
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// Check whether the '{' or ';' under the cursor precedes an element of
+// the body of an interface type, e.g.:
+//   interface { io.# }
+//   interface { Close(); # }
+func (ti *tokenIterator) isInterfaceElem() bool {
+	switch ti.token().tok {
+	case token.LBRACE, token.SEMICOLON:
+	default:
+		return false
+	}
+	if !ti.skipToLeftCurly() || !ti.prev() {
+		return false
+	}
+	return ti.token().tok == token.INTERFACE
+}
+
+// isElidedLiteral reports whether the cursor is in a composite literal
+// whose type is elided, as it is an element of an enclosing literal:
+//   []Struct{{Hel#}}
+//   map[string]Struct{"a": {Hel#}}
+// The iterator is left at the token before the literal.
+func (ti *tokenIterator) isElidedLiteral() bool {
+	if !ti.skipToLeftCurly() || !ti.prev() {
+		return false
+	}
+	switch ti.token().tok {
+	case token.COMMA, token.LBRACE, token.COLON:
+		return true
+	}
+	return false
+}
+
+// Check whether the token under the cursor is the '(' of a type assertion,
+// i.e. it is preceded by a '.'.
+func (ti *tokenIterator) isTypeAssertion() bool {
+	if ti.token().tok != token.LPAREN || !ti.prev() {
+		return false
+	}
+	return ti.token().tok == token.PERIOD
+}
+
+// Check whether the '<-' under the cursor is the receive operation of a
+// select communication clause, i.e. one of:
+//   case <-#
+//   case v := <-#
+//   case v, ok = <-#
+func (ti *tokenIterator) isSelectReceive() bool {
+	if !ti.prev() {
+		return false
+	}
+	switch ti.token().tok {
+	case token.CASE:
+		return true
+	case token.DEFINE, token.ASSIGN:
+	default:
+		return false
+	}
+	for ti.prev() {
+		switch ti.token().tok {
+		case token.IDENT, token.COMMA:
+			// part of the receiving identifier list
+		case token.CASE:
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// Move the cursor back over the expressions of a case clause to the
+// 'case' keyword, e.g. from the ',' in:
+//   case Red, #
+// It returns false if the cursor is not in the expressions of a case
+// clause.
+func (ti *tokenIterator) skipToCase() bool {
+	for ti.token().tok != token.CASE {
+		switch ti.token().tok {
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if !ti.skipToBalancedPair() {
+				return false
+			}
+		case token.SEMICOLON, token.COLON, token.LBRACE, token.LPAREN, token.LBRACK:
+			return false
+		}
+		if !ti.prev() {
+			return false
+		}
+	}
+	return true
+}
+
+// Move the cursor from a 'case' keyword back to the enclosing switch
+// statement and extract its tag expression, e.g.:
+//   switch x := f(); x.kind { case # // returns "x . kind"
+// It returns false if the case clause is not in a switch statement
+// with a tag, e.g. in a select statement. The tag of a type switch
+// ends with ". ( type )".
+func (ti *tokenIterator) extractSwitchTag() (string, bool) {
+	if !ti.skipToLeftCurly() || !ti.prev() {
+		return "", false
+	}
+	end := ti.pos + 1
+	for {
+		switch ti.token().tok {
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if !ti.skipToBalancedPair() {
+				return "", false
+			}
+		case token.SWITCH, token.SEMICOLON:
+			tag := joinTokens(ti.tokens[ti.pos+1 : end])
+			if tag == "" || ti.token().tok == token.SWITCH {
+				return tag, tag != ""
+			}
+			// Skip the init statement.
+			for ti.prev() {
+				switch ti.token().tok {
+				case token.RPAREN, token.RBRACK, token.RBRACE:
+					if !ti.skipToBalancedPair() {
+						return "", false
+					}
+				case token.SWITCH:
+					return tag, true
+				case token.LBRACE, token.SEMICOLON:
+					return "", false
+				}
+			}
+			return "", false
+		case token.LBRACE, token.SELECT:
+			return "", false
+		}
+		if !ti.prev() {
+			return "", false
+		}
+	}
+}
+
+// Check whether the '(' under the cursor opens the receiver of a method
+// declaration, i.e. follows a 'func' keyword starting a declaration.
+func (ti *tokenIterator) isReceiver() bool {
+	if !ti.prev() || ti.token().tok != token.FUNC {
+		return false
+	}
+	return !ti.prev() || ti.token().tok == token.SEMICOLON
+}
+
+// Starting from the identifier under the cursor, check whether it is
+// the last of the names of a variable declaration, so that the type of
+// the variables follows, e.g.:
+//   var x #
+//   var (
+//   	a = 1
+//   	b, c #
+//   )
+func (ti *tokenIterator) isVarSpecType() bool {
+	// Skip the names.
+	for ti.token().tok == token.IDENT {
+		if !ti.prev() {
+			return false
+		}
+		if ti.token().tok != token.COMMA {
+			break
+		}
+		if !ti.prev() {
+			return false
+		}
+	}
+	switch ti.token().tok {
+	case token.VAR:
+		return true
+	case token.LPAREN:
+	case token.SEMICOLON:
+		// Skip the preceding specs of the group, which may contain
+		// balanced brackets of their own, up to its opening paren.
+		balance := 0
+		for balance >= 0 {
+			if !ti.prev() {
+				return false
+			}
+			switch ti.token().tok {
+			case token.RPAREN, token.RBRACK, token.RBRACE:
+				balance++
+			case token.LPAREN:
+				balance--
+			case token.LBRACK, token.LBRACE:
+				if balance == 0 {
+					// In a block or literal rather than a group.
+					return false
+				}
+				balance--
+			}
+		}
+	default:
+		return false
+	}
+	return ti.prev() && ti.token().tok == token.VAR
+}
+
+// receiverTypeAfter returns the receiver type following the receiver
+// name under the cursor, e.g. "*Server" for:
+//   func (s# *Server) Name()
+// It returns "" if there is no type yet.
+func receiverTypeAfter(file []byte, cursor int) string {
+	notIdent := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}
+	// Skip the rest of the name.
+	rest := string(file[cursor:])
+	if i := strings.IndexFunc(rest, notIdent); i >= 0 {
+		rest = rest[i:]
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	star := ""
+	if strings.HasPrefix(rest, "*") {
+		star = "*"
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+	i := strings.IndexFunc(rest, notIdent)
+	if i <= 0 {
+		return ""
+	}
+	return star + rest[:i]
 }
 
 // Given a slice of token_item, reassembles them into the original literal
-// expression.
+// expression. Tokens the scanner considers illegal (e.g. stray '$' or '#'
+// characters) can't be part of an expression, so they yield "".
 func joinTokens(tokens []tokenItem) string {
 	var buf bytes.Buffer
 	for i, tok := range tokens {
+		if tok.tok == token.ILLEGAL {
+			return ""
+		}
 		if i > 0 {
 			buf.WriteByte(' ')
 		}
@@ -260,10 +946,133 @@ const (
 	unknownContext cursorContext = iota
 	selectContext
 	compositeLiteralContext
+	receiveContext
+	typeAssertionContext
+	comparisonContext
+	returnContext
+	formatStringContext
+	importPathContext
+	typeArgumentContext
+	labelContext
+	interfaceEmbedContext
+	elementLiteralContext
+	buildConstraintContext
+	receiverContext
+	structTagContext
+	compoundAssignContext
+	varTypeContext
+	packageClauseContext
 )
 
-func deduceCursorContext(file []byte, cursor int) (cursorContext, string, string) {
-	iter, off := newTokenIterator(file, cursor)
+var cursorContextNames = [...]string{
+	unknownContext:          "unknown",
+	selectContext:           "select",
+	compositeLiteralContext: "compositeLiteral",
+	receiveContext:          "receive",
+	typeAssertionContext:    "typeAssertion",
+	comparisonContext:       "comparison",
+	returnContext:           "return",
+	formatStringContext:     "formatString",
+	importPathContext:       "importPath",
+	typeArgumentContext:     "typeArgument",
+	labelContext:            "label",
+	interfaceEmbedContext:   "interfaceEmbed",
+	elementLiteralContext:   "elementLiteral",
+	buildConstraintContext:  "buildConstraint",
+	receiverContext:         "receiver",
+	structTagContext:        "structTag",
+	compoundAssignContext:   "compoundAssign",
+	varTypeContext:          "varType",
+	packageClauseContext:    "packageClause",
+}
+
+func (ctx cursorContext) String() string {
+	return cursorContextNames[ctx]
+}
+
+// CursorInfo describes the syntactic context of a cursor position.
+type CursorInfo struct {
+	// Kind is the kind of context, e.g. "select" after a '.' or
+	// "formatString" inside the format string of a printf-like call.
+	Kind string
+
+	// Expr is the expression the context refers to, e.g. the operand
+	// before the '.' or the name of the printf-like function.
+	Expr string
+
+	// Partial is the part of the identifier before the cursor.
+	Partial string
+
+	// StatementStart reports whether the cursor (or the partial
+	// identifier) is at the beginning of a statement.
+	StatementStart bool
+}
+
+// DeduceCursorInfo returns the syntactic context of the cursor in file,
+// as Config.DeduceCursorInfo does with the default configuration.
+func DeduceCursorInfo(file []byte, cursor int) CursorInfo {
+	return new(Config).DeduceCursorInfo(file, cursor)
+}
+
+// DeduceCursorInfo returns the syntactic context of the cursor in file.
+// The kind is "unknown" if the cursor is not within file.
+func (c *Config) DeduceCursorInfo(file []byte, cursor int) CursorInfo {
+	if cursor < 0 || cursor > len(file) {
+		return CursorInfo{Kind: unknownContext.String()}
+	}
+	dc := c.deduce("", file, cursor)
+	return CursorInfo{
+		Kind:           dc.ctx.String(),
+		Expr:           dc.expr,
+		Partial:        dc.partial,
+		StatementStart: dc.statementStart(),
+	}
+}
+
+// switchCase returns the context of the expressions of a case clause
+// of a switch statement with the given tag.
+func switchCase(tag, partial string) (cursorContext, string, string) {
+	if strings.HasSuffix(tag, ". ( type )") {
+		// switch x.(type) { case # }
+		return typeAssertionContext, "", partial
+	}
+	// As for comparisons with the tag:
+	// switch status { case # }
+	return comparisonContext, tag, partial
+}
+
+// deducedContext is the context of the cursor in a buffer, deduced once
+// per request from the tokens before the cursor.
+type deducedContext struct {
+	ctx     cursorContext
+	expr    string
+	partial string
+
+	data   []byte
+	cursor int
+	tokens []tokenItem
+	reused int // the number of tokens reused from the token cache
+}
+
+// deduce scans the tokens of data, the buffer of filename, before
+// cursor and deduces the context of cursor from them.
+func (c *Config) deduce(filename string, data []byte, cursor int) *deducedContext {
+	dc := &deducedContext{data: data, cursor: cursor}
+	if cursor < 0 || cursor > len(data) {
+		dc.ctx = unknownContext
+		return dc
+	}
+	dc.tokens, dc.reused = scanTokens(filename, data, cursor)
+	dc.ctx, dc.expr, dc.partial = deduceCursorContext(data, cursor, dc.tokens, c.PrintfFuncs)
+	return dc
+}
+
+func deduceCursorContext(file []byte, cursor int, tokens []tokenItem, printfFuncs []string) (cursorContext, string, string) {
+	if partial, ok := buildConstraintPartial(file, cursor); ok {
+		// //go:build linux && #
+		return buildConstraintContext, "", partial
+	}
+	iter, off := newTokenIterator(tokens, cursor)
 	if len(iter.tokens) == 0 {
 		return unknownContext, "", ""
 	}
@@ -275,28 +1084,166 @@ func deduceCursorContext(file []byte, cursor int) (cursorContext, string, string
 		// we're '<whatever>.<ident>'
 		// parse <ident> as Partial and figure out decl
 
-		partial = tok.String()
 		// If it happens that the cursor is past the end of the literal,
 		// means there is a space between the literal and the cursor, think
-		// of it as no context, because that's what it really is.
+		// of it as no context, because that's what it really is. A keyword
+		// however is the context itself, e.g. "return #".
 		if off > len(tok.String()) {
-			return unknownContext, "", ""
+			if tok.tok == token.IDENT {
+				if vi := iter; vi.isVarSpecType() {
+					// var x #
+					return varTypeContext, "", ""
+				}
+				return unknownContext, "", ""
+			}
+			break
 		}
-		partial = partial[:off]
+		partial = tok.String()[:off]
 
 		if !iter.prev() {
 			return unknownContext, "", partial
 		}
+	case tok.tok == token.ILLEGAL:
+		return unknownContext, "", ""
+	case tok.tok == token.STRING && insideLiteral(tok.lit, off):
+		if ii := iter; off > 0 && off <= len(tok.lit) && ii.isImportPath() {
+			return importPathContext, "", tok.lit[1:off]
+		}
+		if ti := iter; off > 0 && off <= len(tok.lit) && ti.isStructTag() {
+			// struct { Name string `json:"#"` }
+			if key, value, ok := structTagValue(tok.lit[:off]); ok {
+				return structTagContext, key, value
+			}
+			return unknownContext, "", ""
+		}
+		if fn := iter.extractFormatFunc(printfFuncs); fn != "" {
+			return formatStringContext, fn, ""
+		}
 	}
 
 	switch iter.token().tok {
 	case token.PERIOD:
-		return selectContext, iter.extractExpr(), partial
+		expr := iter.extractExpr()
+		if expr == "" {
+			// Nothing to select from, e.g. "$.#".
+			return unknownContext, "", ""
+		}
+		if ei := iter; ei.isInterfaceElem() {
+			// interface { pkg.# }
+			return interfaceEmbedContext, expr, partial
+		}
+		if iter.isTypeAssertion() {
+			// x.(pkg.#)
+			return typeAssertionContext, expr, partial
+		}
+		return selectContext, expr, partial
+	case token.EQL, token.NEQ:
+		// This can happen for enum-like comparisons:
+		// if status == #
+		return comparisonContext, iter.extractExpr(), partial
+	case token.ADD_ASSIGN, token.SUB_ASSIGN, token.MUL_ASSIGN, token.QUO_ASSIGN,
+		token.REM_ASSIGN, token.AND_ASSIGN, token.OR_ASSIGN, token.XOR_ASSIGN,
+		token.AND_NOT_ASSIGN:
+		// The operand must have the type of the variable, unlike the
+		// shift count of <<= and >>=:
+		// count += #
+		return compoundAssignContext, iter.extractExpr(), partial
+	case token.COLON:
+		// This can happen for enum-like struct field values:
+		// Config{Level: #}
+		// The field is selected from a zero value, to compare with.
+		if fi := iter; fi.prev() && fi.token().tok == token.IDENT {
+			field := fi.token().lit
+			if fi.prev() && (fi.token().tok == token.LBRACE || fi.token().tok == token.COMMA) {
+				if typ := fi.extractLiteralType(); typ != "" {
+					return comparisonContext, typ + " { } . " + field, partial
+				}
+			}
+		}
+	case token.LBRACK:
+		if ti := iter; ti.isTypeDeclArgs() {
+			// type IntList = List[#]
+			return typeArgumentContext, iter.extractExpr(), partial
+		}
+	case token.CASE:
+		if tag, ok := iter.extractSwitchTag(); ok {
+			return switchCase(tag, partial)
+		}
+	case token.LPAREN:
+		if ri := iter; ri.isReceiver() {
+			// func (# *Server) Name()
+			// The receiver type follows the cursor.
+			if typ := receiverTypeAfter(file, cursor); typ != "" {
+				return receiverContext, typ, partial
+			}
+		}
+		if bi := iter; bi.prev() && bi.token().tok == token.IDENT {
+			// new(#) and make(#) take a type.
+			if name := bi.token().lit; name == "new" || name == "make" {
+				if !bi.prev() || bi.token().tok != token.PERIOD {
+					return typeArgumentContext, name, partial
+				}
+			}
+		}
+		if iter.isTypeAssertion() {
+			// x.(#)
+			return typeAssertionContext, "", partial
+		}
+	case token.RETURN:
+		return returnContext, "", partial
+	case token.PACKAGE:
+		// package #
+		return packageClauseContext, "", partial
+	case token.BREAK, token.CONTINUE:
+		// break #
+		return labelContext, iter.token().String(), partial
+	case token.SEMICOLON:
+		if ei := iter; ei.isInterfaceElem() {
+			// interface { Read(); # }
+			return interfaceEmbedContext, "", partial
+		}
 	case token.COMMA, token.LBRACE:
+		if ei := iter; ei.isInterfaceElem() {
+			// interface { # }
+			return interfaceEmbedContext, "", partial
+		}
+		if ai := iter; ai.skipToTypeArgs() > 0 {
+			// NewCache[string, #]
+			return typeArgumentContext, ai.extractExpr(), partial
+		}
+		if ri := iter; ri.skipToReturn() >= 0 {
+			// return a, #
+			return returnContext, "", partial
+		}
+		if ci := iter; ci.skipToCase() {
+			// case Red, #
+			if tag, ok := ci.extractSwitchTag(); ok {
+				return switchCase(tag, partial)
+			}
+			return unknownContext, "", partial
+		}
+		if ei := iter; ei.isElidedLiteral() {
+			// map[string]Struct{"a": {Wor#}}
+			// The type of the enclosing literal is extracted.
+			return elementLiteralContext, ei.extractLiteralType(), partial
+		}
 		// This can happen for struct fields:
 		// &Struct{Hello: 1, Wor#} // (# - the cursor)
 		// Let's try to find the struct type
 		return compositeLiteralContext, iter.extractLiteralType(), partial
+	case token.IDENT:
+		if vi := iter; vi.isVarSpecType() {
+			// var (
+			// 	x Str#
+			// )
+			return varTypeContext, "", partial
+		}
+	case token.ARROW:
+		// This can happen for select communication clauses:
+		// case v := <-#
+		if iter.isSelectReceive() {
+			return receiveContext, "", partial
+		}
 	}
 
 	return unknownContext, "", partial
@@ -0,0 +1,69 @@
+package suggest
+
+import (
+	"errors"
+	"fmt"
+	"go/scanner"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var (
+	// ErrCursorOutOfRange is returned if the cursor is not within the
+	// buffer.
+	ErrCursorOutOfRange = errors.New("cursor out of range")
+
+	// ErrFileNotFound is returned if the file being completed doesn't
+	// exist on disk, so its package can't be located.
+	ErrFileNotFound = errors.New("file not found")
+)
+
+// LoadError is returned if the package of the file being completed
+// could not be loaded.
+type LoadError struct {
+	Err error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("loading package: %v", e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// SyntaxError is returned if no candidates were found in a file with
+// syntax errors before the line of the cursor, e.g. as it uses syntax
+// newer than the parser supports. The parser recovers from them, so
+// candidates are still found on a best-effort basis.
+type SyntaxError struct {
+	Errors scanner.ErrorList
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Errors.Error()
+}
+
+// TypeCheckError is returned if no candidates were found in a package
+// that doesn't type check. It is usually transient, e.g. while the user
+// is typing. Soft errors, such as unused imports, are not reported.
+type TypeCheckError struct {
+	Errors []packages.Error
+}
+
+func (e *TypeCheckError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more errors)", e.Errors[0], len(e.Errors)-1)
+}
+
+// isSoftError reports whether msg is the message of a soft type error,
+// i.e. an unused import, variable or label. Unlike types.Error,
+// packages.Error doesn't record whether an error is soft.
+func isSoftError(msg string) bool {
+	return strings.HasSuffix(msg, "and not used") ||
+		strings.Contains(msg, "declared and not used") ||
+		strings.Contains(msg, "declared but not used")
+}
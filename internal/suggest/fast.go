@@ -0,0 +1,325 @@
+package suggest
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fastSuggest implements Config.Fast. It resolves names from the syntax
+// of the package alone, without loading dependencies or type checking,
+// so candidates carry no type information and selectors can't be
+// completed.
+func (c *Config) fastSuggest(filename string, dc *deducedContext) ([]Candidate, int) {
+	ctx, expr, partial := dc.ctx, dc.expr, dc.partial
+	c.logf(LogDebug, "Context: %v, expression: %q, partial: %q\n", ctx, expr, partial)
+	switch {
+	case ctx == selectContext, ctx == formatStringContext, ctx == labelContext,
+		ctx == typeAssertionContext && expr != "", ctx == interfaceEmbedContext,
+		ctx == receiverContext:
+		return nil, 0
+	}
+
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, filename, dc.data, 0)
+	if file == nil || file.Name == nil {
+		return nil, 0
+	}
+	tfile := fset.File(file.Pos())
+	if dc.cursor > tfile.Size() {
+		return nil, 0
+	}
+	pos := tfile.Pos(dc.cursor)
+
+	// Derive the package path as the go command would, so that ranking
+	// by accepted candidates applies across modes.
+	pkg := types.NewPackage(c.dirImportPath(filepath.Dir(filename)), file.Name.Name)
+	b := candidateCollector{
+		localpkg:   pkg,
+		imports:    file.Imports,
+		partial:    partial,
+		filter:     objectFilters[partial],
+		builtin:    c.Builtin && !c.NoBuiltins,
+		ignoreCase: c.IgnoreCase,
+		initials:   c.MatchInitials,
+		adaptive:   c.AdaptiveRanking,
+		visibility: c.Visibility,
+		packages:   c.packageAllowed,
+	}
+	if c.WithPositions {
+		b.fset = fset
+	}
+	if ctx == typeAssertionContext || ctx == typeArgumentContext || ctx == varTypeContext {
+		b.allow = isTypeOrPackage
+	}
+
+	seen := make(map[string]bool)
+	appendObject := func(obj types.Object) {
+		if !seen[obj.Name()] {
+			seen[obj.Name()] = true
+			b.appendObject(obj)
+		}
+	}
+
+	// Innermost declarations shadow outer ones, so visit them first.
+	fastLocals(file, pos, pkg, appendObject)
+	for _, spec := range file.Imports {
+		fastImport(spec, pkg, appendObject)
+	}
+	fastTopLevel(file, pkg, appendObject)
+	for _, other := range c.fastSiblingFiles(fset, filename, file.Name.Name) {
+		fastTopLevel(other, pkg, appendObject)
+	}
+	for _, name := range types.Universe.Names() {
+		appendObject(types.Universe.Lookup(name))
+	}
+
+	res := b.getCandidates()
+	if len(res) == 0 {
+		return nil, 0
+	}
+	return res, len(partial)
+}
+
+// fastSiblingFiles parses the other files of the package in the
+// directory of filename, or their unsaved buffers in Config.Overlay,
+// including those that exist only there.
+func (c *Config) fastSiblingFiles(fset *token.FileSet, filename, pkgName string) []*ast.File {
+	dir := filepath.Dir(filename)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	onDisk := make(map[string]bool)
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+			onDisk[info.Name()] = true
+		}
+	}
+	for path := range c.Overlay {
+		if name := filepath.Base(path); filepath.Dir(path) == dir && !onDisk[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	ctxt := build.Default
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		if buf, ok := c.overlay(path); ok {
+			return ioutil.NopCloser(bytes.NewReader(buf)), nil
+		}
+		return os.Open(path)
+	}
+	var files []*ast.File
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if sameFile(filename, path) {
+			continue
+		}
+		if match, err := ctxt.MatchFile(dir, name); err != nil || !match {
+			continue
+		}
+		var src interface{}
+		if buf, ok := c.overlay(path); ok {
+			src = buf
+		}
+		f, _ := parser.ParseFile(fset, path, src, 0)
+		if f != nil && f.Name != nil && f.Name.Name == pkgName {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func fastImport(spec *ast.ImportSpec, pkg *types.Package, appendObject func(types.Object)) {
+	importPath, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return
+	}
+	assumed := assumedPackageName(importPath)
+	name, pos := assumed, spec.Pos()
+	if spec.Name != nil {
+		name, pos = spec.Name.Name, spec.Name.Pos()
+	}
+	if name == "." {
+		return
+	}
+	appendObject(types.NewPkgName(pos, pkg, name, types.NewPackage(importPath, assumed)))
+}
+
+// assumedPackageName returns the name of the package importPath without
+// reading its package clause, as the go command suggests naming it: the
+// last element of importPath without a major version suffix, e.g. "yaml"
+// for "gopkg.in/yaml.v2" and "chi" for "github.com/go-chi/chi/v5".
+func assumedPackageName(importPath string) string {
+	base := path.Base(importPath)
+	if isMajorVersion(base) && path.Dir(importPath) != "." {
+		base = path.Base(path.Dir(importPath))
+	}
+	if i := strings.LastIndex(base, "."); i > 0 && isMajorVersion(base[i+1:]) {
+		base = base[:i]
+	}
+	if name := dirPackageName(base); name != "" {
+		return name
+	}
+	return base
+}
+
+// isMajorVersion reports whether elem is a major version suffix of an
+// import path, such as "v2".
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' || elem[1] == '0' {
+		return false
+	}
+	for _, r := range elem[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func fastTopLevel(file *ast.File, pkg *types.Package, appendObject func(types.Object)) {
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil && decl.Name.Name != "init" {
+				appendObject(types.NewFunc(decl.Name.Pos(), pkg, decl.Name.Name, nil))
+			}
+		case *ast.GenDecl:
+			fastGenDecl(decl, pkg, appendObject)
+		}
+	}
+}
+
+func fastGenDecl(decl *ast.GenDecl, pkg *types.Package, appendObject func(types.Object)) {
+	for _, spec := range decl.Specs {
+		switch spec := spec.(type) {
+		case *ast.TypeSpec:
+			appendObject(types.NewTypeName(spec.Name.Pos(), pkg, spec.Name.Name, nil))
+		case *ast.ValueSpec:
+			for _, name := range spec.Names {
+				if decl.Tok == token.CONST {
+					appendObject(types.NewConst(name.Pos(), pkg, name.Name, nil, nil))
+				} else {
+					appendObject(types.NewVar(name.Pos(), pkg, name.Name, nil))
+				}
+			}
+		}
+	}
+}
+
+// fastLocals reports the function-local declarations that are visible
+// at pos, innermost first.
+func fastLocals(file *ast.File, pos token.Pos, pkg *types.Package, appendObject func(types.Object)) {
+	var scopes [][]types.Object
+	declare := func(ids ...*ast.Ident) {
+		for _, id := range ids {
+			if id.Pos() < pos {
+				top := len(scopes) - 1
+				scopes[top] = append(scopes[top], types.NewVar(id.Pos(), pkg, id.Name, nil))
+			}
+		}
+	}
+	declareFields := func(fields *ast.FieldList) {
+		if fields != nil {
+			for _, field := range fields.List {
+				declare(field.Names...)
+			}
+		}
+	}
+
+	// Only nodes enclosing the cursor are descended into, so every
+	// scope pushed below is still open at pos.
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil || n.Pos() > pos {
+			return false
+		}
+		encloses := pos <= n.End()
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if !encloses {
+				return false
+			}
+			scopes = append(scopes, nil)
+			declareFields(n.Recv)
+			declareFields(n.Type.Params)
+			declareFields(n.Type.Results)
+		case *ast.FuncLit:
+			if !encloses {
+				return false
+			}
+			scopes = append(scopes, nil)
+			declareFields(n.Type.Params)
+			declareFields(n.Type.Results)
+		case *ast.BlockStmt, *ast.IfStmt, *ast.ForStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt,
+			*ast.SelectStmt, *ast.CaseClause, *ast.CommClause:
+			if !encloses {
+				return false
+			}
+			if len(scopes) > 0 {
+				scopes = append(scopes, nil)
+			}
+		case *ast.RangeStmt:
+			if !encloses {
+				return false
+			}
+			if len(scopes) > 0 {
+				scopes = append(scopes, nil)
+				if n.Tok == token.DEFINE && n.X.End() < pos {
+					for _, x := range []ast.Expr{n.Key, n.Value} {
+						if id, ok := x.(*ast.Ident); ok {
+							declare(id)
+						}
+					}
+				}
+			}
+		case *ast.AssignStmt:
+			if encloses {
+				// The cursor is in the right hand side.
+				return true
+			}
+			if len(scopes) > 0 && n.Tok == token.DEFINE {
+				for _, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok {
+						declare(id)
+					}
+				}
+			}
+			return false
+		case *ast.DeclStmt:
+			if encloses {
+				return true
+			}
+			if decl, ok := n.Decl.(*ast.GenDecl); ok && len(scopes) > 0 {
+				top := len(scopes) - 1
+				fastGenDecl(decl, pkg, func(obj types.Object) {
+					scopes[top] = append(scopes[top], obj)
+				})
+			}
+			return false
+		}
+		return encloses
+	})
+
+	for i := len(scopes) - 1; i >= 0; i-- {
+		for _, obj := range scopes[i] {
+			appendObject(obj)
+		}
+	}
+}
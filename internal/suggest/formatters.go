@@ -1,9 +1,11 @@
 package suggest
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 )
 
 type Formatter func(w io.Writer, candidates []Candidate, num int)
@@ -11,9 +13,13 @@ type Formatter func(w io.Writer, candidates []Candidate, num int)
 var Formatters = map[string]Formatter{
 	"csv":              csvFormat,
 	"csv-with-package": csvFormat,
+	"csv-header":       csvHeaderFormat,
+	"csv-rows":         csvRowsFormat,
 	"emacs":            emacsFormat,
 	"godit":            goditFormat,
+	"grouped":          groupedFormat,
 	"json":             jsonFormat,
+	"json-grouped":     jsonGroupedFormat,
 	"nice":             NiceFormat,
 	"vim":              vimFormat,
 }
@@ -30,6 +36,23 @@ func NiceFormat(w io.Writer, candidates []Candidate, num int) {
 	}
 }
 
+// groupedFormat is like NiceFormat with the candidates listed in
+// sections by class.
+func groupedFormat(w io.Writer, candidates []Candidate, num int) {
+	if candidates == nil {
+		fmt.Fprintf(w, "Nothing to complete.\n")
+		return
+	}
+
+	fmt.Fprintf(w, "Found %d candidates:\n", len(candidates))
+	for _, g := range GroupByClass(candidates) {
+		fmt.Fprintf(w, "%s (%d):\n", g.Class, len(g.Candidates))
+		for _, c := range g.Candidates {
+			fmt.Fprintf(w, "  %s\n", strings.TrimPrefix(c.String(), c.Class+" "))
+		}
+	}
+}
+
 func vimFormat(w io.Writer, candidates []Candidate, num int) {
 	if candidates == nil {
 		fmt.Fprint(w, "[0, []]")
@@ -77,6 +100,27 @@ func csvFormat(w io.Writer, candidates []Candidate, num int) {
 	}
 }
 
+// csvHeaderFormat writes the candidates as RFC 4180 CSV records of
+// name, class, type and package, preceded by a header record.
+func csvHeaderFormat(w io.Writer, candidates []Candidate, num int) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "class", "type", "package"})
+	writeCSVRecords(cw, candidates)
+}
+
+// csvRowsFormat is like csvHeaderFormat without the header, for
+// appending to existing output.
+func csvRowsFormat(w io.Writer, candidates []Candidate, num int) {
+	writeCSVRecords(csv.NewWriter(w), candidates)
+}
+
+func writeCSVRecords(cw *csv.Writer, candidates []Candidate) {
+	for _, c := range candidates {
+		cw.Write([]string{c.Name, c.Class, c.Type, c.PkgPath})
+	}
+	cw.Flush()
+}
+
 func jsonFormat(w io.Writer, candidates []Candidate, num int) {
 	var x []interface{}
 	if candidates != nil {
@@ -84,3 +128,11 @@ func jsonFormat(w io.Writer, candidates []Candidate, num int) {
 	}
 	json.NewEncoder(w).Encode(x)
 }
+
+func jsonGroupedFormat(w io.Writer, candidates []Candidate, num int) {
+	var x []interface{}
+	if candidates != nil {
+		x = []interface{}{num, GroupByClass(candidates)}
+	}
+	json.NewEncoder(w).Encode(x)
+}
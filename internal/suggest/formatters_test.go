@@ -89,6 +89,16 @@ func,,client_drop_cache,,func(cli *rpc.Client, Arg0 int) int,,gocode
 func,,client_highlight,,func(cli *rpc.Client, Arg0 []byte, Arg1 string, Arg2 gocode_env) (c []highlight_range, d int),,gocode
 func,,client_set,,func(cli *rpc.Client, Arg0, Arg1 string) string,,gocode
 func,,client_status,,func(cli *rpc.Client, Arg0 int) string,,gocode
+`[1:]},
+		{"csv-header", `
+name,class,type,package
+client_auto_complete,func,"func(cli *rpc.Client, Arg0 []byte, Arg1 string, Arg2 int, Arg3 gocode_env) (c []candidate, d int)",gocode
+client_close,func,"func(cli *rpc.Client, Arg0 int) int",gocode
+client_cursor_type_pkg,func,"func(cli *rpc.Client, Arg0 []byte, Arg1 string, Arg2 int) (typ, pkg string)",gocode
+client_drop_cache,func,"func(cli *rpc.Client, Arg0 int) int",gocode
+client_highlight,func,"func(cli *rpc.Client, Arg0 []byte, Arg1 string, Arg2 gocode_env) (c []highlight_range, d int)",gocode
+client_set,func,"func(cli *rpc.Client, Arg0, Arg1 string) string",gocode
+client_status,func,"func(cli *rpc.Client, Arg0 int) string",gocode
 `[1:]},
 	}
 
@@ -101,3 +111,54 @@ func,,client_status,,func(cli *rpc.Client, Arg0 int) string,,gocode
 		}
 	}
 }
+
+func TestCSVQuoting(t *testing.T) {
+	candidates := []suggest.Candidate{{
+		Class:   "var",
+		PkgPath: "p",
+		Name:    "s",
+		Type:    `struct{f int "json:\"f,omitempty\""}`,
+	}}
+	want := `s,var,"struct{f int ""json:\""f,omitempty\""""}",p` + "\n"
+
+	var out bytes.Buffer
+	suggest.Formatters["csv-rows"](&out, candidates, 0)
+	if got := out.String(); got != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", got, want)
+	}
+}
+
+func TestGroupedFormats(t *testing.T) {
+	candidates := []suggest.Candidate{
+		{Class: "func", PkgPath: "p", Name: "Len", Type: "func() int"},
+		{Class: "func", PkgPath: "p", Name: "Reset", Type: "func()"},
+		{Class: "var", PkgPath: "p", Name: "Cap", Type: "int"},
+		{Class: "func", PkgPath: "p", Name: "Grow", Type: "func(n int)"},
+	}
+
+	var tests = [...]struct {
+		name string
+		want string
+	}{
+		{"grouped", `
+Found 4 candidates:
+func (3):
+  Len() int
+  Reset()
+  Grow(n int)
+var (1):
+  Cap int
+`[1:]},
+		{"json-grouped", `[0,[{"class":"func","candidates":[{"class":"func","package":"p","name":"Len","type":"func() int"},{"class":"func","package":"p","name":"Reset","type":"func()"},{"class":"func","package":"p","name":"Grow","type":"func(n int)"}]},{"class":"var","candidates":[{"class":"var","package":"p","name":"Cap","type":"int"}]}]]
+`},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		suggest.Formatters[test.name](&out, candidates, 0)
+
+		if got := out.String(); got != test.want {
+			t.Errorf("Format %s:\nGot:\n%q\nWant:\n%q\n", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package suggest
+
+import (
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// TypeInfo describes a named type.
+type TypeInfo struct {
+	Name    string `json:"name"`
+	PkgPath string `json:"package"`
+	// Pointer is set if only the pointer type is in the method set,
+	// i.e. some methods have pointer receivers.
+	Pointer bool `json:"pointer,omitempty"`
+}
+
+// Implementers returns the exported concrete types that implement the
+// interface interfaceName declared in the package importPath, sorted by
+// package, then by name. To bound the cost, only the package importPath
+// and its direct imports are searched, so implementations elsewhere,
+// e.g. in packages importing importPath, are not found.
+func (c *Config) Implementers(importPath, interfaceName string) []TypeInfo {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedImports,
+	}
+	if c.Context != nil {
+		cfg.Env = c.Context.Env
+		cfg.Dir = c.Context.Dir
+		cfg.BuildFlags = c.Context.BuildFlags
+	}
+	pkgs, _ := packages.Load(cfg, importPath)
+	if len(pkgs) <= 0 { // ignore errors
+		return nil
+	}
+
+	// The imports of a package loaded from export data only contain the
+	// objects it refers to, so its direct imports are loaded along with
+	// it, which also makes them share their types.
+	patterns := []string{importPath}
+	for path := range pkgs[0].Imports {
+		patterns = append(patterns, path)
+	}
+	cfg.Mode = packages.LoadTypes
+	pkgs, _ = packages.Load(cfg, patterns...)
+	var target *types.Package
+	var searched []*types.Package
+	for _, pkg := range pkgs {
+		if pkg.Types == nil { // ignore errors
+			continue
+		}
+		if pkg.PkgPath == importPath {
+			target = pkg.Types
+		}
+		searched = append(searched, pkg.Types)
+	}
+	if target == nil {
+		return nil
+	}
+
+	obj, ok := target.Scope().Lookup(interfaceName).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+	iface, ok := obj.Type().Underlying().(*types.Interface)
+	if !ok {
+		return nil
+	}
+
+	var res []TypeInfo
+	for _, pkg := range searched {
+		scope := pkg.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || !tn.Exported() || tn.IsAlias() {
+				continue
+			}
+			named, ok := tn.Type().(*types.Named)
+			if !ok || named.TypeParams().Len() > 0 || types.IsInterface(named) {
+				continue
+			}
+			switch {
+			case types.Implements(named, iface):
+				res = append(res, TypeInfo{Name: name, PkgPath: pkg.Path()})
+			case types.Implements(types.NewPointer(named), iface):
+				res = append(res, TypeInfo{Name: name, PkgPath: pkg.Path(), Pointer: true})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].PkgPath != res[j].PkgPath {
+			return res[i].PkgPath < res[j].PkgPath
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
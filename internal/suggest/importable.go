@@ -0,0 +1,381 @@
+package suggest
+
+import (
+	"bytes"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PackageInfo describes an importable package.
+type PackageInfo struct {
+	ImportPath string `json:"path"`
+	Name       string `json:"name"`
+	Standard   bool   `json:"standard"`
+}
+
+var importable struct {
+	mu     sync.Mutex
+	index  map[string]*packageRoots // by GOROOT, GOPATH and GOMODCACHE
+	hits   int
+	misses int
+}
+
+// packageRoots is the package index of one GOROOT, GOPATH and module
+// cache.
+type packageRoots struct {
+	goroot, gopath, modcache string
+	pkgs                     []PackageInfo          // sorted by import path
+	mod                      map[string]PackageInfo // found in the module cache
+}
+
+// CacheStats describes the use of the importable package index.
+type CacheStats struct {
+	Hits     int // lookups answered from the index
+	Misses   int // lookups that (re)built the index
+	Packages int // packages currently indexed
+}
+
+// ImportableCacheStats returns statistics on the package index used by
+// ImportablePackages.
+func ImportableCacheStats() CacheStats {
+	importable.mu.Lock()
+	defer importable.mu.Unlock()
+	st := CacheStats{Hits: importable.hits, Misses: importable.misses}
+	for _, r := range importable.index {
+		st.Packages += len(r.pkgs)
+	}
+	return st
+}
+
+// ImportablePackages returns the packages found in GOROOT, GOPATH and
+// the module cache whose import path starts with prefix, sorted by
+// import path. The package index is built on first use and cached
+// until RefreshImportablePackages is called.
+func (c *Config) ImportablePackages(prefix string) []PackageInfo {
+	return withPrefix(c.packageIndex(), prefix)
+}
+
+// withPrefix returns the packages of the sorted pkgs whose import path
+// starts with prefix.
+func withPrefix(pkgs []PackageInfo, prefix string) []PackageInfo {
+	var res []PackageInfo
+	i := sort.Search(len(pkgs), func(i int) bool { return pkgs[i].ImportPath >= prefix })
+	for ; i < len(pkgs) && strings.HasPrefix(pkgs[i].ImportPath, prefix); i++ {
+		res = append(res, pkgs[i])
+	}
+	return res
+}
+
+// packageIndex returns the importable packages of the context, sorted
+// by import path.
+func (c *Config) packageIndex() []PackageInfo {
+	goroot, gopath, modcache := c.goEnv()
+	key := strings.Join([]string{goroot, gopath, modcache}, string(filepath.ListSeparator))
+
+	importable.mu.Lock()
+	r, ok := importable.index[key]
+	if ok {
+		importable.hits++
+	} else {
+		importable.misses++
+	}
+	importable.mu.Unlock()
+	if ok {
+		return r.pkgs
+	}
+	r = &packageRoots{goroot: goroot, gopath: gopath, modcache: modcache}
+	r.index()
+	importable.mu.Lock()
+	if importable.index == nil {
+		importable.index = make(map[string]*packageRoots)
+	}
+	importable.index[key] = r
+	importable.mu.Unlock()
+	return r.pkgs
+}
+
+// importPathCandidates returns the packages whose import path matches
+// prefix, as selected by Config.PathMatch, as "import" candidates, whose
+// type is the package name. Paths starting with prefix come first.
+func (c *Config) importPathCandidates(prefix string) []Candidate {
+	index := c.packageIndex()
+	pkgs := withPrefix(index, prefix)
+	if c.PathMatch == PathMatchSegment && prefix != "" {
+		for _, pkg := range index {
+			if !strings.HasPrefix(pkg.ImportPath, prefix) && strings.Contains(pkg.ImportPath, "/"+prefix) {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+
+	var res []Candidate
+	for _, pkg := range pkgs {
+		res = append(res, Candidate{
+			Class:   "import",
+			PkgPath: pkg.ImportPath,
+			Name:    pkg.ImportPath,
+			Type:    pkg.Name,
+		})
+	}
+	return res
+}
+
+// RefreshImportablePackages drops the cached package index used by
+// ImportablePackages.
+func RefreshImportablePackages() {
+	importable.mu.Lock()
+	importable.index = nil
+	importable.mu.Unlock()
+}
+
+// InvalidatePackages updates the cached package index for the packages
+// with the given import paths, which may have been added, changed or
+// removed in GOROOT or GOPATH. The rest of the index is kept.
+func InvalidatePackages(paths []string) {
+	importable.mu.Lock()
+	defer importable.mu.Unlock()
+	for _, r := range importable.index {
+		byPath := make(map[string]PackageInfo, len(r.pkgs))
+		for _, pkg := range r.pkgs {
+			byPath[pkg.ImportPath] = pkg
+		}
+		for _, path := range paths {
+			delete(byPath, path)
+			if pkg, ok := r.lookup(path); ok {
+				byPath[path] = pkg
+			} else if pkg, ok := r.mod[path]; ok {
+				byPath[path] = pkg
+			}
+		}
+		// Replace the slice rather than modify it, as callers may still
+		// hold the old one.
+		pkgs := make([]PackageInfo, 0, len(byPath))
+		for _, pkg := range byPath {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].ImportPath < pkgs[j].ImportPath })
+		r.pkgs = pkgs
+	}
+}
+
+// lookup returns the package with the given import path in GOROOT or
+// GOPATH, the first one found winning as in index.
+func (r *packageRoots) lookup(path string) (PackageInfo, bool) {
+	for _, elem := range strings.Split(path, "/") {
+		if skipDir(elem) {
+			return PackageInfo{}, false
+		}
+	}
+	dir := filepath.FromSlash(path)
+	if !strings.HasPrefix(path, "cmd/") && path != "cmd" {
+		if name := packageName(filepath.Join(r.goroot, "src", dir)); name != "" && name != "main" {
+			return PackageInfo{ImportPath: path, Name: name, Standard: true}, true
+		}
+	}
+	for _, root := range filepath.SplitList(r.gopath) {
+		if name := packageName(filepath.Join(root, "src", dir)); name != "" && name != "main" {
+			return PackageInfo{ImportPath: path, Name: name}, true
+		}
+	}
+	return PackageInfo{}, false
+}
+
+// ResetCaches drops everything cached across requests: the package index
+// used by ImportablePackages, the tokens of the buffers completed in and
+// the decoded config files. Acceptances for adaptive ranking are kept.
+func ResetCaches() {
+	RefreshImportablePackages()
+	resetTokenCache()
+	resetConfigFiles()
+}
+
+// goEnv returns the GOROOT, GOPATH and GOMODCACHE of the context,
+// falling back to those of the current environment. As for the go
+// command, the module cache defaults to pkg/mod in the first GOPATH
+// entry.
+func (c *Config) goEnv() (goroot, gopath, modcache string) {
+	goroot, gopath = build.Default.GOROOT, build.Default.GOPATH
+	modcache = os.Getenv("GOMODCACHE")
+	if c.Context != nil {
+		for _, kv := range c.Context.Env {
+			switch {
+			case strings.HasPrefix(kv, "GOROOT="):
+				goroot = strings.TrimPrefix(kv, "GOROOT=")
+			case strings.HasPrefix(kv, "GOPATH="):
+				gopath = strings.TrimPrefix(kv, "GOPATH=")
+			case strings.HasPrefix(kv, "GOMODCACHE="):
+				modcache = strings.TrimPrefix(kv, "GOMODCACHE=")
+			}
+		}
+	}
+	if list := filepath.SplitList(gopath); modcache == "" && len(list) > 0 {
+		modcache = filepath.Join(list[0], "pkg", "mod")
+	}
+	return
+}
+
+// dirImportPath returns the import path of the package in dir, as given
+// by the go.mod file of its module or by its location in a GOPATH src
+// directory, or "" if it is in neither.
+func (c *Config) dirImportPath(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for d := dir; ; {
+		if data, err := ioutil.ReadFile(filepath.Join(d, "go.mod")); err == nil {
+			mod := modFilePath(data)
+			rel, err := filepath.Rel(d, dir)
+			if mod == "" || err != nil {
+				return ""
+			}
+			return path.Join(mod, filepath.ToSlash(rel))
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			break
+		}
+		d = parent
+	}
+	_, gopath, _ := c.goEnv()
+	for _, root := range filepath.SplitList(gopath) {
+		rel, err := filepath.Rel(filepath.Join(root, "src"), dir)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return ""
+}
+
+// modFilePath returns the module path declared by the go.mod file data,
+// or "" if there is none.
+func modFilePath(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if f := strings.Fields(line); len(f) >= 2 && f[0] == "module" {
+			return strings.Trim(f[1], `"`)
+		}
+	}
+	return ""
+}
+
+// index fills r.pkgs by walking GOROOT, GOPATH and the module cache.
+func (r *packageRoots) index() {
+	seen := make(map[string]bool)
+	add := func(info PackageInfo) {
+		if !seen[info.ImportPath] {
+			seen[info.ImportPath] = true
+			r.pkgs = append(r.pkgs, info)
+		}
+	}
+
+	// Commands in GOROOT and downloads in the module cache aren't
+	// importable.
+	walkPackages(filepath.Join(r.goroot, "src"), "cmd", func(path, name string) {
+		add(PackageInfo{ImportPath: path, Name: name, Standard: true})
+	})
+	for _, dir := range filepath.SplitList(r.gopath) {
+		walkPackages(filepath.Join(dir, "src"), "", func(path, name string) {
+			add(PackageInfo{ImportPath: path, Name: name})
+		})
+	}
+	r.mod = make(map[string]PackageInfo)
+	if r.modcache != "" {
+		walkPackages(r.modcache, "cache", func(path, name string) {
+			if path, ok := modulePath(path); ok {
+				info := PackageInfo{ImportPath: path, Name: name}
+				if _, ok := r.mod[path]; !ok {
+					r.mod[path] = info
+				}
+				add(info)
+			}
+		})
+	}
+
+	sort.Slice(r.pkgs, func(i, j int) bool { return r.pkgs[i].ImportPath < r.pkgs[j].ImportPath })
+}
+
+// walkPackages calls fn with the slash-separated path relative to root
+// and the package name of each importable package below root. If skip is
+// not empty, the directory of that name right below root is skipped.
+func walkPackages(root, skip string, fn func(path, name string)) {
+	filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if dir != root && (skipDir(info.Name()) || skip != "" && dir == filepath.Join(root, skip)) {
+			return filepath.SkipDir
+		}
+		if name := packageName(dir); name != "" && name != "main" {
+			rel, err := filepath.Rel(root, dir)
+			if err == nil && rel != "." {
+				fn(filepath.ToSlash(rel), name)
+			}
+		}
+		return nil
+	})
+}
+
+// skipDir reports whether the directory named base and everything below
+// it is left out of the package index.
+func skipDir(base string) bool {
+	return base == "testdata" || base == "internal" || base == "vendor" ||
+		strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_")
+}
+
+// packageName returns the package name of the first non-test Go file
+// in dir, or "" if there is none.
+func packageName(dir string) string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err == nil && f.Name.Name != "documentation" {
+			return f.Name.Name
+		}
+	}
+	return ""
+}
+
+// modulePath converts a path relative to the module cache, such as
+// "github.com/!foo/bar@v1.0.0/baz", to an import path.
+func modulePath(path string) (string, bool) {
+	i := strings.Index(path, "@")
+	if i < 0 {
+		return "", false
+	}
+	rest := path[i:]
+	if j := strings.Index(rest, "/"); j >= 0 {
+		rest = rest[j:]
+	} else {
+		rest = ""
+	}
+
+	var buf bytes.Buffer
+	escaped := false
+	for _, r := range path[:i] {
+		switch {
+		case r == '!':
+			escaped = true
+			continue
+		case escaped && 'a' <= r && r <= 'z':
+			r += 'A' - 'a'
+		}
+		escaped = false
+		buf.WriteRune(r)
+	}
+	return buf.String() + rest, true
+}
@@ -0,0 +1,72 @@
+package suggest
+
+import (
+	"os"
+	"strings"
+)
+
+// LogLevel is the verbosity of the messages passed to Config.Logf.
+type LogLevel string
+
+const (
+	LogOff   LogLevel = "off"
+	LogInfo  LogLevel = "info"  // a summary of each request
+	LogDebug LogLevel = "debug" // the deduced cursor context
+	LogTrace LogLevel = "trace" // the tokens before the cursor
+)
+
+// LogLevelEnv is the environment variable setting the log level if
+// Config.LogLevel is empty.
+const LogLevelEnv = "GOCODE_LOG"
+
+var logLevelRanks = map[LogLevel]int{
+	LogOff:   0,
+	LogInfo:  1,
+	LogDebug: 2,
+	LogTrace: 3,
+}
+
+// logEnabled reports whether messages of the given level are logged.
+func (c *Config) logEnabled(level LogLevel) bool {
+	if c.Logf == nil {
+		return false
+	}
+	l := c.LogLevel
+	if l == "" {
+		l = LogLevel(os.Getenv(LogLevelEnv))
+	}
+	return logLevelRanks[l] >= logLevelRanks[level]
+}
+
+// logf calls Logf if messages of the given level are logged. Arguments
+// that are costly to compute should be guarded by logEnabled instead.
+func (c *Config) logf(level LogLevel, format string, args ...interface{}) {
+	if c.logEnabled(level) {
+		c.Logf(format, args...)
+	}
+}
+
+// traceTokens logs the last tokens before the cursor of dc and how
+// many were reused from the token cache.
+func (c *Config) traceTokens(dc *deducedContext) {
+	const max = 16
+
+	tokens := dc.tokens
+	c.Logf("Tokens: %d, reused from cache: %d\n", len(tokens), dc.reused)
+
+	if len(tokens) > max {
+		tokens = tokens[len(tokens)-max:]
+	}
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if t.lit != "" && t.tok.IsLiteral() {
+			b.WriteString(t.lit)
+		} else {
+			b.WriteString(t.tok.String())
+		}
+	}
+	c.Logf("Tokens before cursor: %s\n", b.String())
+}
@@ -0,0 +1,41 @@
+package suggest
+
+import (
+	"go/types"
+
+	"github.com/stamblerre/gocode/internal/lookdot"
+	"golang.org/x/tools/go/packages"
+)
+
+// TypeMembers returns the exported fields and methods of the named type
+// typeName declared in the package importPath, including promoted
+// ones. The methods of the pointer type are included, as for an
+// addressable variable of the type. Candidates are ordered by class
+// (methods before fields), then by name.
+func (c *Config) TypeMembers(importPath, typeName string) []Candidate {
+	cfg := &packages.Config{
+		Mode: packages.LoadTypes,
+	}
+	if c.Context != nil {
+		cfg.Env = c.Context.Env
+		cfg.Dir = c.Context.Dir
+		cfg.BuildFlags = c.Context.BuildFlags
+	}
+	pkgs, _ := packages.Load(cfg, importPath)
+	if len(pkgs) <= 0 || pkgs[0].Types == nil { // ignore errors
+		return nil
+	}
+
+	obj, ok := pkgs[0].Types.Scope().Lookup(typeName).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+
+	// No local package, so that only exported members are collected.
+	b := candidateCollector{recv: c.WithSignatures}
+	if c.WithPositions {
+		b.fset = pkgs[0].Fset
+	}
+	lookdot.WalkVar(obj.Type(), b.appendObject)
+	return b.getCandidates()
+}
@@ -0,0 +1,67 @@
+package suggest
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+)
+
+// NodeInfo describes a syntax node enclosing the cursor.
+type NodeInfo struct {
+	// Kind is the type of the node in go/ast, without the package,
+	// e.g. "IfStmt" or "Ident".
+	Kind string `json:"kind"`
+
+	// Start and End are the byte offsets of the node in the file; the
+	// node spans [Start, End).
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// NodePath returns the syntax nodes of the file filename enclosing the
+// cursor, from the *ast.File down to the innermost node, e.g. File,
+// FuncDecl, BlockStmt, IfStmt and so on. A node ending at the cursor
+// encloses it, as does the file in any case. If src is nil, the file is
+// read from disk. The file is parsed with the parser's error recovery,
+// so incomplete code yields the nodes the parser could make out of it,
+// possibly BadExpr or BadStmt nodes.
+func (c *Config) NodePath(filename string, src []byte, cursor int) []NodeInfo {
+	fset := token.NewFileSet()
+	var source interface{}
+	if src != nil {
+		source = src
+	}
+	file, _ := parser.ParseFile(fset, filename, source, parser.AllErrors)
+	if file == nil {
+		return nil
+	}
+	tfile := fset.File(file.Pos())
+	if tfile == nil || cursor < 0 || cursor > tfile.Size() {
+		return nil
+	}
+	pos := tfile.Pos(cursor)
+
+	// Sibling nodes may both touch the cursor, e.g. in "a;#b", so only
+	// the first one is descended into.
+	var path []NodeInfo
+	depth := 0
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			depth--
+			return false
+		}
+		if depth != len(path) || n != file && (pos < n.Pos() || n.End() < pos) {
+			return false
+		}
+		path = append(path, NodeInfo{
+			Kind:  strings.TrimPrefix(reflect.TypeOf(n).String(), "*ast."),
+			Start: tfile.Offset(n.Pos()),
+			End:   tfile.Offset(n.End()),
+		})
+		depth++
+		return true
+	})
+	return path
+}
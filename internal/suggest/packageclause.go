@@ -0,0 +1,70 @@
+package suggest
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// packageClauseCandidates returns the package names starting with prefix
+// for the package clause of the file filename, as "package" candidates:
+// the name of the other files in its directory, the name derived from
+// the directory, and main, in this order. The names of external test
+// packages are proposed for test files.
+func packageClauseCandidates(filename, prefix string) []Candidate {
+	dir := filepath.Dir(filename)
+	var names []string
+	if infos, err := ioutil.ReadDir(dir); err == nil {
+		for _, info := range infos {
+			name := info.Name()
+			path := filepath.Join(dir, name)
+			if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || info.IsDir() || sameFile(path, filename) {
+				continue
+			}
+			if f, _ := parser.ParseFile(token.NewFileSet(), path, nil, parser.PackageClauseOnly); f != nil && f.Name != nil {
+				names = append(names, f.Name.Name)
+				break
+			}
+		}
+	}
+	if name := dirPackageName(dir); name != "" {
+		names = append(names, name)
+	}
+	names = append(names, "main")
+	if strings.HasSuffix(filename, "_test.go") && names[0] != "main" {
+		names = append(names, names[0]+"_test")
+	}
+
+	var res []Candidate
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !seen[name] && strings.HasPrefix(name, prefix) {
+			seen[name] = true
+			res = append(res, Candidate{Class: "package", Name: name})
+		}
+	}
+	return res
+}
+
+// dirPackageName returns the conventional package name for the directory
+// dir, i.e. its base name in lower case, without a "go-" prefix or a
+// ".go" suffix, and with the characters invalid in identifiers removed,
+// e.g. "yaml" for "go-yaml". It returns "" if there is none.
+func dirPackageName(dir string) string {
+	base := strings.ToLower(filepath.Base(dir))
+	base = strings.TrimPrefix(base, "go-")
+	base = strings.TrimSuffix(base, ".go")
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return -1
+	}, base)
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		return ""
+	}
+	return name
+}
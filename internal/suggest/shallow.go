@@ -0,0 +1,144 @@
+package suggest
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// checkShallow type checks the syntax of pkg, the package of filename
+// loaded with cfg, importing packages from source as deep as
+// Config.MaxImportDepth. It returns the type errors other than soft
+// ones.
+func (c *Config) checkShallow(reqCtx context.Context, cfg *packages.Config, filename string, pkg *packages.Package) (*types.Package, *types.Info, []packages.Error) {
+	// The import graph is listed with the same settings as the package,
+	// but without parsing or type checking any dependency.
+	graphCfg := *cfg
+	graphCfg.Mode = packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps
+	graphCfg.ParseFile = nil
+	imp := &shallowImporter{
+		c:        c,
+		fset:     pkg.Fset,
+		maxDepth: c.MaxImportDepth,
+		depth:    make(map[string]int),
+		pkgs:     make(map[string]*types.Package),
+	}
+	if roots, _ := packages.Load(&graphCfg, fmt.Sprintf("file=%v", filename)); len(roots) > 0 && reqCtx.Err() == nil {
+		imp.root = roots[0]
+		imp.measure()
+	}
+
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	var hard []packages.Error
+	conf := types.Config{
+		Importer: imp.importer(imp.root),
+		Error: func(err error) {
+			if terr, ok := err.(types.Error); ok && !terr.Soft {
+				hard = append(hard, packages.Error{
+					Pos:  pkg.Fset.Position(terr.Pos).String(),
+					Msg:  terr.Msg,
+					Kind: packages.TypeError,
+				})
+			}
+		},
+	}
+	tpkg, _ := conf.Check(pkg.PkgPath, pkg.Fset, pkg.Syntax, info)
+	return tpkg, info, hard
+}
+
+// shallowImporter imports the packages of an import graph by type
+// checking their source without function bodies, down to a maximum
+// import depth. Packages beyond it are empty stubs.
+type shallowImporter struct {
+	c        *Config
+	fset     *token.FileSet
+	maxDepth int
+	root     *packages.Package
+	depth    map[string]int            // by package ID
+	pkgs     map[string]*types.Package // by package ID
+}
+
+// measure records the depth of each package of the import graph, i.e.
+// the length of the shortest import chain from the root to it, so that
+// each package is type checked once however it is reached.
+func (imp *shallowImporter) measure() {
+	queue := []*packages.Package{imp.root}
+	imp.depth[imp.root.ID] = 0
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, dep := range p.Imports {
+			if _, ok := imp.depth[dep.ID]; !ok {
+				imp.depth[dep.ID] = imp.depth[p.ID] + 1
+				queue = append(queue, dep)
+			}
+		}
+	}
+}
+
+// importer returns the importer of the imports of from.
+func (imp *shallowImporter) importer(from *packages.Package) types.Importer {
+	return importerFunc(func(path string) (*types.Package, error) {
+		if path == "unsafe" {
+			return types.Unsafe, nil
+		}
+		var dep *packages.Package
+		if from != nil {
+			dep = from.Imports[path]
+		}
+		if dep == nil {
+			return nil, fmt.Errorf("can't find package %q", path)
+		}
+		return imp.load(dep), nil
+	})
+}
+
+func (imp *shallowImporter) load(p *packages.Package) *types.Package {
+	if pkg := imp.pkgs[p.ID]; pkg != nil {
+		return pkg
+	}
+	if imp.depth[p.ID] > imp.maxDepth {
+		pkg := types.NewPackage(p.PkgPath, p.Name)
+		pkg.MarkComplete()
+		imp.pkgs[p.ID] = pkg
+		return pkg
+	}
+
+	var files []*ast.File
+	for _, filename := range p.GoFiles {
+		var src interface{}
+		if buf, ok := imp.c.overlay(filename); ok {
+			src = buf
+		}
+		f, _ := parser.ParseFile(imp.fset, filename, src, 0)
+		if f == nil {
+			continue
+		}
+		for _, decl := range f.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok {
+				fd.Body = nil
+			}
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{
+		Importer:    imp.importer(p),
+		Error:       func(error) {}, // keep going with the declarations
+		FakeImportC: true,
+	}
+	pkg, _ := conf.Check(p.PkgPath, imp.fset, files, nil)
+	imp.pkgs[p.ID] = pkg
+	return pkg
+}
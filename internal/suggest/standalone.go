@@ -0,0 +1,107 @@
+package suggest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// standalonePath is the import path of a file type checked on its own,
+// as the go command names the package of files listed on its command
+// line.
+const standalonePath = "command-line-arguments"
+
+// analyzeStandalone is like analyzePackage, but type checks filename on
+// its own, as a package of a single file. It is used for files excluded
+// from the package of their directory by their build constraints, which
+// are usually programs run with "go run", e.g. generators.
+func (c *Config) analyzeStandalone(reqCtx context.Context, filename string, data []byte, cursor int) (*token.FileSet, token.Pos, *types.Package, *types.Info, *ast.File, error) {
+	fset := token.NewFileSet()
+	mode := parser.AllErrors
+	if c.ParseComments {
+		mode |= parser.ParseComments
+	}
+	src := bytes.Join([][]byte{data[:cursor], []byte(";"), data[cursor:]}, nil)
+	file, err := parser.ParseFile(fset, filename, src, mode)
+	if file == nil || file.Package == token.NoPos {
+		return nil, token.NoPos, nil, nil, nil, &LoadError{Err: fmt.Errorf("no package for %s", filename)}
+	}
+	pos := fset.File(file.Pos()).Pos(cursor)
+	syntaxErrs := syntaxErrorsBefore(fset, pos, err)
+
+	var paths []string
+	for _, spec := range file.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil && path != "unsafe" {
+			paths = append(paths, path)
+		}
+	}
+	imported := map[string]*types.Package{"unsafe": types.Unsafe}
+	if len(paths) > 0 {
+		cfg := &packages.Config{
+			Context:    reqCtx,
+			Mode:       packages.LoadTypes,
+			Env:        c.Context.Env,
+			Dir:        c.Context.Dir,
+			BuildFlags: c.buildFlags(""),
+			Overlay:    c.Overlay,
+			Fset:       fset,
+		}
+		pkgs, _ := packages.Load(cfg, paths...) // ignore errors
+		if err := reqCtx.Err(); err != nil {
+			return nil, token.NoPos, nil, nil, nil, err
+		}
+		for _, pkg := range pkgs {
+			if pkg.Types != nil {
+				imported[pkg.PkgPath] = pkg.Types
+			}
+		}
+	}
+
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	var hard []packages.Error
+	conf := types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if pkg := imported[path]; pkg != nil {
+				return pkg, nil
+			}
+			return nil, fmt.Errorf("can't find package %q", path)
+		}),
+		Error: func(err error) {
+			if terr, ok := err.(types.Error); ok && !terr.Soft {
+				hard = append(hard, packages.Error{
+					Pos:  fset.Position(terr.Pos).String(),
+					Msg:  terr.Msg,
+					Kind: packages.TypeError,
+				})
+			}
+		},
+	}
+	pkg, _ := conf.Check(standalonePath, fset, []*ast.File{file}, info)
+
+	if len(syntaxErrs) > 0 {
+		err = &SyntaxError{Errors: syntaxErrs}
+	} else if len(hard) > 0 {
+		err = &TypeCheckError{Errors: hard}
+	} else {
+		err = nil
+	}
+	return fset, pos, pkg, info, file, err
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
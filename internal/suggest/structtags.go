@@ -0,0 +1,48 @@
+package suggest
+
+import (
+	"sort"
+	"strings"
+)
+
+// structTagValue parses the struct tag lit, a string literal up to the
+// cursor, in the conventional format of reflect.StructTag. If the cursor
+// is in the value of a key, it returns the key and the part of the
+// value after the last comma, e.g. "json" and "omit" for `json:"a,omit`.
+func structTagValue(lit string) (key, partial string, ok bool) {
+	tag := lit[1:] // skip the opening quote
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		i := strings.Index(tag, `:"`)
+		if i < 0 {
+			return "", "", false
+		}
+		key, tag = tag[:i], tag[i+2:]
+		j := strings.IndexByte(tag, '"')
+		for j > 0 && tag[j-1] == '\\' {
+			if k := strings.IndexByte(tag[j+1:], '"'); k >= 0 {
+				j += k + 1
+			} else {
+				j = -1
+			}
+		}
+		if j < 0 {
+			value := tag
+			return key, value[strings.LastIndexByte(value, ',')+1:], true
+		}
+		tag = tag[j+1:]
+	}
+}
+
+// structTagCandidates returns the values registered in Config.StructTags
+// for key that start with prefix, as "tag" candidates whose type is key.
+func (c *Config) structTagCandidates(key, prefix string) []Candidate {
+	var res []Candidate
+	for _, value := range c.StructTags[key] {
+		if strings.HasPrefix(value, prefix) {
+			res = append(res, Candidate{Class: "tag", Name: value, Type: key})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
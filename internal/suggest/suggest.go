@@ -2,25 +2,192 @@ package suggest
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"go/ast"
+	"go/build"
+	"go/importer"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"go/types"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/stamblerre/gocode/internal/lookdot"
 	"golang.org/x/tools/go/packages"
 )
 
 type Config struct {
-	Logf       func(fmt string, args ...interface{})
+	Logf func(fmt string, args ...interface{})
+
+	// LogLevel selects the messages passed to Logf. If empty, it is read
+	// from the LogLevelEnv environment variable, and defaults to LogOff.
+	LogLevel LogLevel
+
 	Context    *PackedContext
 	Builtin    bool
 	IgnoreCase bool
+
+	// NoBuiltins excludes the identifiers of the universe scope, such
+	// as len or int, even if Builtin is set. Declarations shadowing
+	// them are still proposed.
+	NoBuiltins bool
+
+	// MatchInitials additionally proposes candidates whose word
+	// initials start with the partial identifier, e.g. userCount for
+	// "uc". They are ranked with the other candidates, after those of
+	// the same class matching by prefix.
+	MatchInitials bool
+
+	// AdaptiveRanking lists the candidates recorded by
+	// RecordAcceptance first, the most frequently accepted first.
+	AdaptiveRanking bool
+
+	// Fast resolves candidates from the syntax of the package only,
+	// skipping type checking. Candidates have coarse classes and no
+	// types, and selector expressions are not completed.
+	Fast bool
+
+	// WithSignatures reports the receiver of each method candidate in
+	// Candidate.Recv, so that Candidate.Signature can render it.
+	WithSignatures bool
+
+	// QualifyNames prefixes the names of the candidates of a selector
+	// expression with its operand, e.g. "fmt.Println" for "fmt.Pr", so
+	// that they are valid code on their own. The returned length then
+	// covers the operand too.
+	QualifyNames bool
+
+	// Mod, if non-empty, is passed to the go command as the -mod build
+	// flag, e.g. "vendor" to resolve imports from the vendor directory.
+	// It overrides -mod in Context.BuildFlags and in GOFLAGS, which is
+	// otherwise honored.
+	Mod string
+
+	// Visibility restricts candidates to exported or unexported
+	// identifiers. By default, all accessible identifiers are proposed,
+	// i.e. only exported ones from other packages. Package names and
+	// builtins are not restricted.
+	Visibility Visibility
+
+	// IncludePackages, if non-empty, restricts candidates to those
+	// declared in packages whose import path matches one of the
+	// patterns, as interpreted by path.Match, e.g. "example.com/api/*".
+	// ExcludePackages drops the candidates of matching packages, even
+	// if included. The package being edited and builtins are not
+	// filtered.
+	IncludePackages []string
+	ExcludePackages []string
+
+	// FieldOrder is the order of the field names proposed in struct
+	// literals. It defaults to FieldOrderAlphabetical.
+	FieldOrder FieldOrder
+
+	// StructTags maps struct tag keys to the values proposed in struct
+	// tags, e.g. "validate" to "required", "email" and so on. Values
+	// are proposed after the opening quote or a comma, as in
+	// `validate:"required,em`.
+	StructTags map[string][]string
+
+	// PrintfFuncs names further printf-like functions, whose first
+	// string argument is a format string, as "logf" or "Debugf" for any
+	// function or method of that name, or as "klog.Infof" for that
+	// qualified call only. The printf-like functions and methods of
+	// fmt, log and testing are always recognized.
+	PrintfFuncs []string
+
+	// Overlay holds the contents of unsaved buffers by absolute file
+	// name, e.g. other open files of the package being edited, so that
+	// their declarations are visible. The contents of the file being
+	// completed are always those passed to Suggest.
+	Overlay map[string][]byte
+
+	// PathMatch selects the import paths proposed for a partial path.
+	// It defaults to PathMatchPrefix.
+	PathMatch PathMatchMode
+
+	// ParseComments keeps the comments of the package's files, e.g.
+	// for doc strings. It makes parsing slower and the syntax trees
+	// larger, so it is off by default.
+	ParseComments bool
+
+	// MaxImportDepth, if positive, bounds how deep the imports of the
+	// package are type checked, the direct imports being at depth 1.
+	// Imports are then type checked from source without function
+	// bodies, rather than read from the export data the go command
+	// builds for all dependencies. Deeper packages are empty, so the
+	// types declared in them are invalid.
+	MaxImportDepth int
+
+	// WithPositions reports the declaration position of each
+	// candidate in Candidate.Pos.
+	WithPositions bool
+
+	// WithDeclaredIn reports the interface declaring each interface
+	// method candidate in Candidate.DeclaredIn, which for promoted
+	// methods is the embedded interface.
+	WithDeclaredIn bool
+
+	// Metrics, if non-nil, is called after each Suggest call with
+	// timing information about the request.
+	Metrics func(Metrics)
+}
+
+// Visibility selects candidates by whether they are exported.
+type Visibility string
+
+const (
+	VisibilityAuto       Visibility = ""
+	VisibilityExported   Visibility = "exported"
+	VisibilityUnexported Visibility = "unexported"
+)
+
+// FieldOrder is the order of the fields proposed in struct literals.
+type FieldOrder string
+
+const (
+	FieldOrderAlphabetical FieldOrder = "alphabetical"
+	FieldOrderDeclaration  FieldOrder = "declaration"
+)
+
+// PathMatchMode selects how import paths match a partial path.
+type PathMatchMode string
+
+const (
+	// PathMatchPrefix matches the paths starting with the partial path.
+	PathMatchPrefix PathMatchMode = "prefix"
+
+	// PathMatchSegment additionally matches the paths with a segment
+	// starting with the partial path, e.g. "path/filepath" for "fil".
+	PathMatchSegment PathMatchMode = "segment"
+)
+
+// Metrics describes where the time of a Suggest call was spent.
+type Metrics struct {
+	// Load is the time spent loading and type checking the package.
+	// It includes Parse.
+	Load time.Duration
+
+	// Parse is the time spent parsing source files, summed over all
+	// files of the package.
+	Parse time.Duration
+
+	// Collect is the time spent collecting candidates.
+	Collect time.Duration
+
+	// Total is the duration of the whole Suggest call.
+	Total time.Duration
+
+	// Candidates is the number of candidates returned.
+	Candidates int
 }
 
 // PackedContext is copied from go/packages.Config.
@@ -48,63 +215,313 @@ type PackedContext struct {
 // Suggest returns a list of suggestion candidates and the length of
 // the text that should be replaced, if any.
 func (c *Config) Suggest(filename string, data []byte, cursor int) ([]Candidate, int) {
-	if cursor < 0 {
-		return nil, 0
+	res, n, _ := c.SuggestWithError(filename, data, cursor)
+	return res, n
+}
+
+// SuggestWithError is like Suggest, but if no candidates are found
+// because of a failure, it reports it as ErrCursorOutOfRange,
+// ErrFileNotFound, a *LoadError, a *SyntaxError or a *TypeCheckError.
+func (c *Config) SuggestWithError(filename string, data []byte, cursor int) ([]Candidate, int, error) {
+	return c.SuggestContext(context.Background(), filename, data, cursor)
+}
+
+// SuggestContext is like SuggestWithError, but gives up loading the
+// package once ctx is done, e.g. when a newer request supersedes this
+// one. It then returns the error of ctx.
+func (c *Config) SuggestContext(reqCtx context.Context, filename string, data []byte, cursor int) ([]Candidate, int, error) {
+	if c.Metrics == nil {
+		res, n, err := c.suggest(reqCtx, filename, data, cursor, nil)
+		c.logf(LogInfo, "Completed %s:#%d: %d candidates, error: %v\n", filename, cursor, len(res), err)
+		return res, n, err
 	}
 
-	fset, pos, pkg, imports := c.analyzePackage(filename, data, cursor)
+	var m Metrics
+	start := time.Now()
+	res, n, err := c.suggest(reqCtx, filename, data, cursor, &m)
+	m.Total = time.Since(start)
+	m.Candidates = len(res)
+	c.logf(LogInfo, "Completed %s:#%d: %d candidates in %v, error: %v\n", filename, cursor, len(res), m.Total, err)
+	c.Metrics(m)
+	return res, n, err
+}
+
+// suggest implements SuggestContext. If m is non-nil, it is filled in
+// with the timings of the request.
+func (c *Config) suggest(reqCtx context.Context, filename string, data []byte, cursor int, m *Metrics) ([]Candidate, int, error) {
+	if cursor < 0 || cursor > len(data) {
+		return nil, 0, ErrCursorOutOfRange
+	}
+	dc := c.deduce(filename, data, cursor)
+	if c.logEnabled(LogTrace) {
+		c.traceTokens(dc)
+	}
+	if res, n, ok := c.packageIndependentCandidates(filename, dc); ok {
+		return res, n, nil
+	}
+	if c.Fast {
+		res, n := c.fastSuggest(filename, dc)
+		return res, n, nil
+	}
+
+	var start time.Time
+	if m != nil {
+		start = time.Now()
+	}
+	fset, pos, pkg, info, file, err := c.analyzePackage(reqCtx, filename, data, cursor, m)
 	if pkg == nil {
-		return nil, 0
+		return nil, 0, err
+	}
+	if m != nil {
+		m.Load = time.Since(start)
+		start = time.Now()
+		defer func() { m.Collect = time.Since(start) }()
+	}
+	return c.collect(fset, pos, pkg, info, file, filename, dc, err)
+}
+
+// SuggestFromPackage is like SuggestWithError, but completes in the file
+// filename of pkg, which the caller already loaded with at least
+// packages.NeedSyntax, NeedTypes and NeedTypesInfo, so it is neither
+// parsed nor type checked again. data is the contents of the file pkg
+// was loaded from. Unlike with SuggestWithError, type errors are not
+// reported.
+func (c *Config) SuggestFromPackage(pkg *packages.Package, filename string, data []byte, cursor int) ([]Candidate, int, error) {
+	if cursor < 0 || cursor > len(data) {
+		return nil, 0, ErrCursorOutOfRange
+	}
+	dc := c.deduce(filename, data, cursor)
+	if res, n, ok := c.packageIndependentCandidates(filename, dc); ok {
+		return res, n, nil
+	}
+	if pkg.Fset == nil || pkg.Types == nil || pkg.TypesInfo == nil {
+		return nil, 0, &LoadError{Err: fmt.Errorf("no type information for %s", pkg.PkgPath)}
+	}
+	for _, file := range pkg.Syntax {
+		tfile := pkg.Fset.File(file.Pos())
+		if tfile == nil || !sameFile(tfile.Name(), filename) {
+			continue
+		}
+		if cursor > tfile.Size() {
+			return nil, 0, ErrCursorOutOfRange
+		}
+		return c.collect(pkg.Fset, tfile.Pos(cursor), pkg.Types, pkg.TypesInfo, file, filename, dc, nil)
+	}
+	return nil, 0, &LoadError{Err: fmt.Errorf("no syntax for %s in %s", filename, pkg.PkgPath)}
+}
+
+// packageIndependentCandidates returns the candidates if the cursor is
+// in an import path, a build constraint, a struct tag or the package
+// clause, which don't depend on the package being edited. It returns
+// false otherwise.
+func (c *Config) packageIndependentCandidates(filename string, dc *deducedContext) ([]Candidate, int, bool) {
+	ctx, expr, partial := dc.ctx, dc.expr, dc.partial
+	var res []Candidate
+	switch ctx {
+	case importPathContext:
+		res = c.importPathCandidates(partial)
+	case buildConstraintContext:
+		res = buildConstraintCandidates(partial)
+	case structTagContext:
+		res = c.structTagCandidates(expr, partial)
+	case packageClauseContext:
+		res = packageClauseCandidates(filename, partial)
+	default:
+		return nil, 0, false
+	}
+	if len(res) == 0 {
+		return nil, 0, true
+	}
+	return res, len(partial), true
+}
+
+// collect returns the candidates at pos, the position of the cursor of
+// dc in file. err is returned if there are none, e.g. as they may be
+// missing due to type errors.
+func (c *Config) collect(fset *token.FileSet, pos token.Pos, pkg *types.Package, info *types.Info, file *ast.File, filename string, dc *deducedContext, err error) ([]Candidate, int, error) {
+	if r, size := utf8.DecodeLastRune(dc.data[:dc.cursor]); size > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.') {
+		// The scope of a case clause ends with its last statement,
+		// which may end at the cursor, e.g. "case T:\n\tt.Na#", so
+		// scopes are looked up within the expression being completed.
+		pos -= token.Pos(size)
 	}
 	scope := pkg.Scope().Innermost(pos)
+	if fileScope := info.Scopes[file]; scope == nil && fileScope != nil {
+		// The file scope ends with its last declaration, which the
+		// cursor may be right after.
+		scope = fileScope
+	}
 
-	ctx, expr, partial := deduceCursorContext(data, cursor)
+	ctx, expr, partial := dc.ctx, dc.expr, dc.partial
+	c.logf(LogDebug, "Context: %v, expression: %q, partial: %q\n", ctx, expr, partial)
 	b := candidateCollector{
 		localpkg:   pkg,
-		imports:    imports,
+		imports:    file.Imports,
 		partial:    partial,
 		filter:     objectFilters[partial],
-		builtin:    ctx != selectContext && c.Builtin,
+		builtin:    ctx != selectContext && c.Builtin && !c.NoBuiltins,
 		ignoreCase: c.IgnoreCase,
+		initials:   c.MatchInitials,
+		adaptive:   c.AdaptiveRanking,
+		declaredIn: c.WithDeclaredIn,
+		recv:       c.WithSignatures,
+		visibility: c.Visibility,
+		packages:   c.packageAllowed,
+	}
+	if c.WithPositions {
+		b.fset = fset
 	}
 
 	switch ctx {
+	case formatStringContext:
+		// Format verbs are not Go identifiers.
+		return nil, 0, nil
+
+	case typeAssertionContext:
+		// Only types, possibly qualified by a package, can be asserted.
+		if expr == "" {
+			b.allow = isTypeOrPackage
+			c.scopeCandidates(scope, pos, &b)
+			break
+		}
+		b.allow = objectFilters["type"]
+		fallthrough
 	case selectContext:
 		tv, _ := types.Eval(fset, pkg, pos, expr)
+		if tuple, ok := tv.Type.(*types.Tuple); ok && tuple.Len() > 0 {
+			// Selecting from a multi-value call, e.g. f().x with f
+			// returning (T, error), is invalid. Assume the first result.
+			tv.Type = tuple.At(0).Type()
+		}
+		b.viaInterface = tv.IsValue() && isInterfaceValue(tv.Type)
 		if lookdot.Walk(&tv, b.appendObject) {
 			break
 		}
 
 		_, obj := scope.LookupParent(expr, pos)
 		if pkgName, isPkg := obj.(*types.PkgName); isPkg {
-			c.packageCandidates(pkgName.Imported(), &b)
+			imported := pkgName.Imported()
+			if build.IsLocalImport(imported.Path()) {
+				// go list doesn't load relative imports in module mode.
+				if local := importLocal(fset, filepath.Dir(filename), imported.Path()); local != nil {
+					imported = local
+				}
+			}
+			c.packageCandidates(imported, &b)
 			break
 		}
 
-		return nil, 0
+		return nil, 0, err
+
+	case interfaceEmbedContext:
+		// Only interfaces, possibly qualified by a package, can be embedded.
+		if expr == "" {
+			b.allow = isInterfaceOrPackage
+			c.scopeCandidates(scope, pos, &b)
+			break
+		}
+		b.allow = isInterface
+		_, obj := scope.LookupParent(expr, pos)
+		if pkgName, isPkg := obj.(*types.PkgName); isPkg {
+			c.packageCandidates(pkgName.Imported(), &b)
+		}
+
+	case typeArgumentContext, varTypeContext:
+		b.allow = isTypeOrPackage
+		c.scopeCandidates(scope, pos, &b)
+
+	case labelContext:
+		for _, obj := range branchTargets(file, info, pos, expr == "continue") {
+			b.appendObject(obj)
+		}
+
+	case receiverContext:
+		b.keepOrder = true
+		for _, obj := range receiverNames(pkg, expr) {
+			b.appendObject(obj)
+		}
+
+	case comparisonContext:
+		tv, _ := types.Eval(fset, pkg, pos, expr)
+		b.prefer = constantsOf(tv.Type)
+		c.scopeCandidates(scope, pos, &b)
+
+	case compoundAssignContext:
+		tv, _ := types.Eval(fset, pkg, pos, expr)
+		if tv.Type != nil {
+			b.prefer = assignableTo(tv.Type)
+		}
+		c.scopeCandidates(scope, pos, &b)
+
+	case returnContext:
+		if sig := enclosingSignature(file, info, pos); sig != nil {
+			if i := dc.returnIndex(); i >= 0 && i < sig.Results().Len() {
+				b.prefer = assignableTo(sig.Results().At(i).Type())
+			}
+		}
+		c.scopeCandidates(scope, pos, &b)
+
+	case elementLiteralContext:
+		// The type of the literal is elided, so it is the element type
+		// of the enclosing literal of type expr.
+		tv, _ := types.Eval(fset, pkg, pos, expr)
+		if tv.IsType() {
+			if elem := elementType(tv.Type, dc.keyedElement()); elem != nil {
+				if _, isStruct := elem.Underlying().(*types.Struct); isStruct {
+					b.keepOrder = c.FieldOrder == FieldOrderDeclaration
+					c.fieldNameCandidates(elem, &b)
+					break
+				}
+				b.prefer = elementsOf(elem)
+			}
+		}
+		c.scopeCandidates(scope, pos, &b)
 
 	case compositeLiteralContext:
 		tv, _ := types.Eval(fset, pkg, pos, expr)
 		if tv.IsType() {
 			if _, isStruct := tv.Type.Underlying().(*types.Struct); isStruct {
+				b.keepOrder = c.FieldOrder == FieldOrderDeclaration
 				c.fieldNameCandidates(tv.Type, &b)
 				break
 			}
+			b.prefer = elementsOf(tv.Type)
 		}
 
 		fallthrough
 	default:
+		if ctx == receiveContext {
+			b.prefer = isChan
+		}
 		c.scopeCandidates(scope, pos, &b)
 	}
 
 	res := b.getCandidates()
+	c.logf(LogDebug, "Collected %d candidates\n", len(res))
 	if len(res) == 0 {
-		return nil, 0
+		return nil, 0, err
 	}
-	return res, len(partial)
+	if c.QualifyNames && ctx == selectContext {
+		if base, start := dc.selectorBase(); start >= 0 {
+			for i := range res {
+				res[i].Name = base + "." + res[i].Name
+			}
+			return res, dc.cursor - start, nil
+		}
+	}
+	return res, len(partial), nil
 }
 
-func (c *Config) analyzePackage(filename string, data []byte, cursor int) (*token.FileSet, token.Pos, *types.Package, []*ast.ImportSpec) {
+// analyzePackage loads and type checks the package of filename, with
+// data as the contents of the file. If the package doesn't type check,
+// it is returned along with a *TypeCheckError.
+func (c *Config) analyzePackage(reqCtx context.Context, filename string, data []byte, cursor int, m *Metrics) (*token.FileSet, token.Pos, *types.Package, *types.Info, *ast.File, error) {
+	if _, ok := c.overlay(filename); !ok {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			return nil, token.NoPos, nil, nil, nil, ErrFileNotFound
+		}
+	}
+
 	var tags string
 	parsed, _ := parser.ParseFile(token.NewFileSet(), filename, data, parser.ParseComments)
 	if parsed != nil && len(parsed.Comments) > 0 {
@@ -119,15 +536,33 @@ func (c *Config) analyzePackage(filename string, data []byte, cursor int) (*toke
 
 	var fileAST *ast.File
 	var pos token.Pos
-	var posMu sync.Mutex // guards pos and fileAST in ParseFile
+	var syntaxErrs scanner.ErrorList
+	var posMu sync.Mutex // guards pos, fileAST, syntaxErrs and m in ParseFile
 
+	// Only the package of the file is type checked from source; its
+	// dependencies, however deep, are read from compiler export data,
+	// unless they are type checked below as deep as MaxImportDepth.
+	mode := packages.LoadSyntax
+	if c.MaxImportDepth > 0 {
+		mode = packages.NeedName | packages.NeedFiles | packages.NeedSyntax
+	}
 	cfg := &packages.Config{
-		Mode:       packages.LoadSyntax,
+		Context:    reqCtx,
+		Mode:       mode,
 		Env:        c.Context.Env,
 		Dir:        c.Context.Dir,
-		BuildFlags: append(c.Context.BuildFlags, fmt.Sprintf("-tags=%s", tags)),
+		BuildFlags: c.buildFlags(tags),
 		Tests:      true,
+		Overlay:    c.Overlay,
 		ParseFile: func(fset *token.FileSet, parseFilename string, _ []byte) (*ast.File, error) {
+			if m != nil {
+				start := time.Now()
+				defer func() {
+					posMu.Lock()
+					m.Parse += time.Since(start)
+					posMu.Unlock()
+				}()
+			}
 			var src interface{}
 			var filePos token.Pos
 			mode := parser.DeclarationErrors
@@ -137,6 +572,11 @@ func (c *Config) analyzePackage(filename string, data []byte, cursor int) (*toke
 				// still be in scope there.
 				src = bytes.Join([][]byte{data[:cursor], []byte(";"), data[cursor:]}, nil)
 				mode = parser.AllErrors
+			} else if buf, ok := c.overlay(parseFilename); ok {
+				src = buf
+			}
+			if c.ParseComments {
+				mode |= parser.ParseComments
 			}
 			file, err := parser.ParseFile(fset, parseFilename, src, mode)
 			if file == nil {
@@ -147,11 +587,13 @@ func (c *Config) analyzePackage(filename string, data []byte, cursor int) (*toke
 				if filePos == token.NoPos {
 					return nil, fmt.Errorf("no position for cursor in %s", parseFilename)
 				}
+				before := syntaxErrorsBefore(fset, filePos, err)
 				posMu.Lock()
 				if pos == token.NoPos {
 					pos = filePos
 				}
 				fileAST = file
+				syntaxErrs = before
 				posMu.Unlock()
 			}
 			for _, decl := range file.Decls {
@@ -164,16 +606,206 @@ func (c *Config) analyzePackage(filename string, data []byte, cursor int) (*toke
 			return file, nil
 		},
 	}
-	pkgs, _ := packages.Load(cfg, fmt.Sprintf("file=%v", filename))
-	if len(pkgs) <= 0 { // ignore errors
-		return nil, token.NoPos, nil, nil
+	pkgs, err := packages.Load(cfg, fmt.Sprintf("file=%v", filename))
+	if err := reqCtx.Err(); err != nil {
+		return nil, token.NoPos, nil, nil, nil, err
+	}
+	if err != nil {
+		return nil, token.NoPos, nil, nil, nil, &LoadError{Err: err}
+	}
+	if len(pkgs) <= 0 || fileAST == nil {
+		// The file is excluded from the package of its directory, e.g.
+		// by a "//go:build ignore" constraint.
+		return c.analyzeStandalone(reqCtx, filename, data, cursor)
 	}
 	pkg := pkgs[0]
+	var typeErrs []packages.Error
+	if c.MaxImportDepth > 0 {
+		pkg.Types, pkg.TypesInfo, typeErrs = c.checkShallow(reqCtx, cfg, filename, pkg)
+	} else {
+		// Soft errors, such as unused imports and variables, are common
+		// while editing and don't affect the type information.
+		for _, perr := range pkg.Errors {
+			if perr.Kind == packages.TypeError && !isSoftError(perr.Msg) {
+				typeErrs = append(typeErrs, perr)
+			}
+		}
+	}
+	if pkg.Types == nil {
+		return nil, token.NoPos, nil, nil, nil, &LoadError{Err: fmt.Errorf("no type information for %s", pkg.PkgPath)}
+	}
+	if len(syntaxErrs) > 0 {
+		err = &SyntaxError{Errors: syntaxErrs}
+	} else if len(typeErrs) > 0 {
+		err = &TypeCheckError{Errors: typeErrs}
+	}
+
+	return pkg.Fset, pos, pkg.Types, pkg.TypesInfo, fileAST, err
+}
+
+// syntaxErrorsBefore returns the syntax errors in err, as returned by
+// the parser, on lines before the one of pos. The parser recovers from
+// syntax errors, e.g. due to syntax newer than it supports. Those before
+// the line of the cursor are not due to the code being edited.
+func syntaxErrorsBefore(fset *token.FileSet, pos token.Pos, err error) scanner.ErrorList {
+	var before scanner.ErrorList
+	if list, ok := err.(scanner.ErrorList); ok {
+		line := fset.Position(pos).Line
+		for _, e := range list {
+			if e.Pos.Line < line {
+				before = append(before, e)
+			}
+		}
+	}
+	return before
+}
+
+// isInterfaceValue reports whether a value of type typ has an interface
+// type, which type parameters constrained by an interface don't.
+func isInterfaceValue(typ types.Type) bool {
+	if _, ok := typ.(*types.TypeParam); ok {
+		return false
+	}
+	return types.IsInterface(typ)
+}
+
+// importLocal type checks the package of the relative import path
+// from the directory dir, ignoring errors. It returns nil if there is
+// no such package.
+func importLocal(fset *token.FileSet, dir, path string) *types.Package {
+	bp, err := build.ImportDir(filepath.Join(dir, path), 0)
+	if err != nil {
+		return nil
+	}
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		if f, _ := parser.ParseFile(fset, filepath.Join(bp.Dir, name), nil, 0); f != nil {
+			files = append(files, f)
+		}
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check(path, fset, files, nil)
+	return pkg
+}
 
-	return pkg.Fset, pos, pkg.Types, fileAST.Imports
+// buildFlags returns the build flags to load the package with.
+func (c *Config) buildFlags(tags string) []string {
+	var flags []string
+	for _, flag := range c.Context.BuildFlags {
+		if c.Mod != "" && (strings.HasPrefix(flag, "-mod=") || strings.HasPrefix(flag, "--mod=")) {
+			continue
+		}
+		flags = append(flags, flag)
+	}
+	if c.Mod != "" {
+		flags = append(flags, "-mod="+c.Mod)
+	}
+	return append(flags, fmt.Sprintf("-tags=%s", tags))
+}
+
+// enclosingSignature returns the signature of the innermost function
+// declaration or literal in file enclosing pos.
+func enclosingSignature(file *ast.File, info *types.Info, pos token.Pos) *types.Signature {
+	var sig *types.Signature
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil || pos < n.Pos() || n.End() < pos {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if obj := info.Defs[n.Name]; obj != nil {
+				sig, _ = obj.Type().(*types.Signature)
+			}
+		case *ast.FuncLit:
+			if tv, ok := info.Types[n]; ok {
+				sig, _ = tv.Type.(*types.Signature)
+			}
+		}
+		return true
+	})
+	return sig
+}
+
+// receiverNames proposes names for the receiver of type typ, e.g.
+// "*Server", of a method declaration: the names used by the other
+// methods of the type, most common first, or else its lower-cased
+// initial.
+func receiverNames(pkg *types.Package, typ string) []types.Object {
+	name := strings.TrimPrefix(typ, "*")
+	var recvType types.Type
+	count := make(map[string]int)
+	var names []string
+	if tn, ok := pkg.Scope().Lookup(name).(*types.TypeName); ok {
+		recvType = tn.Type()
+		if named, ok := recvType.(*types.Named); ok {
+			for i := 0; i < named.NumMethods(); i++ {
+				recv := named.Method(i).Type().(*types.Signature).Recv()
+				if recv == nil || recv.Name() == "" || recv.Name() == "_" {
+					continue
+				}
+				if count[recv.Name()] == 0 {
+					names = append(names, recv.Name())
+				}
+				count[recv.Name()]++
+			}
+		}
+		if typ != name {
+			recvType = types.NewPointer(recvType)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool { return count[names[i]] > count[names[j]] })
+	if len(names) == 0 {
+		r, _ := utf8.DecodeRuneInString(name)
+		names = append(names, string(unicode.ToLower(r)))
+	}
+
+	var res []types.Object
+	for _, n := range names {
+		res = append(res, types.NewVar(token.NoPos, pkg, n, recvType))
+	}
+	return res
+}
+
+// branchTargets returns the labels of the statements enclosing pos
+// that a break (or, if isContinue, a continue) statement at pos can
+// refer to.
+func branchTargets(file *ast.File, info *types.Info, pos token.Pos, isContinue bool) []types.Object {
+	var labels []types.Object
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil || pos < n.Pos() || n.End() < pos {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			// Labels don't extend into function literals.
+			labels = nil
+		case *ast.LabeledStmt:
+			switch n.Stmt.(type) {
+			case *ast.ForStmt, *ast.RangeStmt:
+			case *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+				if isContinue {
+					return true
+				}
+			default:
+				return true
+			}
+			if obj := info.Defs[n.Label]; obj != nil {
+				labels = append(labels, obj)
+			}
+		}
+		return true
+	})
+	return labels
 }
 
 func sameFile(filename1, filename2 string) bool {
+	if filepath.Clean(filename1) == filepath.Clean(filename2) {
+		// Either may exist only in Config.Overlay.
+		return true
+	}
 	finfo1, err := os.Stat(filename1)
 	if err != nil {
 		return false
@@ -185,6 +817,20 @@ func sameFile(filename1, filename2 string) bool {
 	return os.SameFile(finfo1, finfo2)
 }
 
+// overlay returns the contents of the unsaved buffer of filename in
+// Config.Overlay, if any.
+func (c *Config) overlay(filename string) ([]byte, bool) {
+	if buf, ok := c.Overlay[filename]; ok {
+		return buf, true
+	}
+	for name, buf := range c.Overlay {
+		if sameFile(name, filename) {
+			return buf, true
+		}
+	}
+	return nil, false
+}
+
 func (c *Config) fieldNameCandidates(typ types.Type, b *candidateCollector) {
 	s := typ.Underlying().(*types.Struct)
 	for i, n := 0, s.NumFields(); i < n; i++ {
@@ -193,9 +839,31 @@ func (c *Config) fieldNameCandidates(typ types.Type, b *candidateCollector) {
 }
 
 func (c *Config) packageCandidates(pkg *types.Package, b *candidateCollector) {
+	if !c.packageAllowed(pkg.Path()) {
+		return
+	}
 	c.scopeCandidates(pkg.Scope(), token.NoPos, b)
 }
 
+// packageAllowed reports whether candidates from the package with the
+// given import path are allowed by IncludePackages and ExcludePackages.
+func (c *Config) packageAllowed(pkgPath string) bool {
+	for _, pattern := range c.ExcludePackages {
+		if ok, _ := path.Match(pattern, pkgPath); ok {
+			return false
+		}
+	}
+	if len(c.IncludePackages) == 0 {
+		return true
+	}
+	for _, pattern := range c.IncludePackages {
+		if ok, _ := path.Match(pattern, pkgPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) scopeCandidates(scope *types.Scope, pos token.Pos, b *candidateCollector) {
 	seen := make(map[string]bool)
 	for scope != nil {
@@ -2,15 +2,20 @@ package suggest_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"go/types"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stamblerre/gocode/internal/suggest"
+	"golang.org/x/tools/go/packages"
 )
 
 func TestRegress(t *testing.T) {
@@ -95,4 +100,1638 @@ func contains(haystack []string, needle string) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// writeTempFile writes src to a file named p.go in a new temporary
+// directory and returns its path. The directory is removed by cleanup.
+func writeTempFile(t *testing.T, src string) (filename string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename = filepath.Join(dir, "p.go")
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func TestPositions(t *testing.T) {
+	const src = "package p\n\nfunc Hello() {}\n\nvar _ = He"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+
+	cfg := suggest.Config{
+		Context:       &suggest.PackedContext{},
+		WithPositions: true,
+	}
+	candidates, _ := cfg.Suggest(filename, []byte(src), len(src))
+	if len(candidates) != 1 {
+		t.Fatalf("got %d candidates, want 1", len(candidates))
+	}
+	pos := candidates[0].Pos
+	if pos == nil {
+		t.Fatalf("missing position for %s", candidates[0].Name)
+	}
+	if !strings.HasSuffix(pos.Filename, "p.go") || pos.Line != 3 || pos.Column != 6 {
+		t.Errorf("got position %v, want p.go:3:6", pos)
+	}
+
+	cfg.WithPositions = false
+	candidates, _ = cfg.Suggest(filename, []byte(src), len(src))
+	if len(candidates) != 1 || candidates[0].Pos != nil {
+		t.Errorf("got %v, want one candidate without position", candidates)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	const src = "package p\n\nfunc Hello() {}\n\nvar _ = He"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+
+	var got []suggest.Metrics
+	cfg := suggest.Config{
+		Context: &suggest.PackedContext{},
+		Metrics: func(m suggest.Metrics) { got = append(got, m) },
+	}
+	candidates, _ := cfg.Suggest(filename, []byte(src), len(src))
+	if len(got) != 1 {
+		t.Fatalf("Metrics called %d times, want 1", len(got))
+	}
+	m := got[0]
+	if m.Candidates != len(candidates) {
+		t.Errorf("got %d candidates in metrics, want %d", m.Candidates, len(candidates))
+	}
+	if m.Load <= 0 || m.Parse <= 0 || m.Total < m.Load+m.Collect {
+		t.Errorf("inconsistent timings: %+v", m)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	sub := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg, path, err := suggest.LoadConfigFile(sub); err != nil || path != "" || cfg.IgnoreCase {
+		t.Fatalf("LoadConfigFile without config file = %+v, %q, %v", cfg, path, err)
+	}
+
+	want := filepath.Join(root, suggest.ConfigFileName)
+	if err := ioutil.WriteFile(want, []byte(`{"IgnoreCase": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, path, err := suggest.LoadConfigFile(sub)
+	if err != nil || path != want {
+		t.Fatalf("LoadConfigFile = %q, %v; want %q", path, err, want)
+	}
+	if !cfg.IgnoreCase || cfg.Builtin {
+		t.Errorf("got %+v, want only IgnoreCase set", cfg)
+	}
+
+	// The file is read again once modified.
+	if err := ioutil.WriteFile(want, []byte(`{"Builtin": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(want, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, _, err := suggest.LoadConfigFile(sub); err != nil || cfg.IgnoreCase || !cfg.Builtin {
+		t.Errorf("got %+v, %v after modifying the config file, want only Builtin set", cfg, err)
+	}
+
+	if err := ioutil.WriteFile(want, []byte(`{"IgnoreCase": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(want, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := suggest.LoadConfigFile(sub); err == nil {
+		t.Errorf("LoadConfigFile succeeded on malformed config file")
+	}
+}
+
+func TestResetCaches(t *testing.T) {
+	root, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, suggest.ConfigFileName)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write := func(src string) {
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"IgnoreCase": true}`)
+	if cfg, _, err := suggest.LoadConfigFile(root); err != nil || !cfg.IgnoreCase {
+		t.Fatalf("got %+v, %v, want IgnoreCase set", cfg, err)
+	}
+
+	// A change that keeps the modification time is only seen once the
+	// caches are reset.
+	write(`{"Builtin": true}`)
+	if cfg, _, err := suggest.LoadConfigFile(root); err != nil || !cfg.IgnoreCase {
+		t.Errorf("got %+v, %v before resetting the caches, want the cached IgnoreCase", cfg, err)
+	}
+	suggest.ResetCaches()
+	if cfg, _, err := suggest.LoadConfigFile(root); err != nil || cfg.IgnoreCase || !cfg.Builtin {
+		t.Errorf("got %+v, %v after resetting the caches, want only Builtin set", cfg, err)
+	}
+}
+
+func TestImportablePackages(t *testing.T) {
+	root, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"goroot/src/fmt/print.go":                            "package fmt",
+		"goroot/src/cmd/go/main.go":                          "package main",
+		"goroot/src/internal/cpu/cpu.go":                     "package cpu",
+		"gopath/src/example.com/foo/foo.go":                  "package foo",
+		"gopath/src/example.com/foo/testdata/x.go":           "package x",
+		"gopath/src/example.com/foo/cmd/foo/main.go":         "package main",
+		"gopath/src/example.com/fmtutil/fmtutil.go":          "package fmtutil",
+		"gopath/pkg/mod/example.com/!bar@v1.0.0/bar.go":      "package bar",
+		"gopath/pkg/mod/example.com/!bar@v1.0.0/sub/sub.go":  "package sub",
+		"gopath/pkg/mod/cache/download/example.com/x/x.go":   "package x",
+		"gopath/pkg/mod/example.com/!bar@v1.0.0/bar_test.go": "package bar_test",
+		"gopath/src/gopkg.in/yaml.v2/yaml.go":                "package yaml",
+		"goroot/src/cmd/asm/arch/arch.go":                    "package arch",
+		"gopath/src/cmd/tool/tool.go":                        "package tool",
+		"modcache/example.com/!baz@v1.2.0/baz.go":            "package baz",
+		"modcache/cache/download/example.com/y/y.go":         "package y",
+	}
+	for name, src := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := suggest.Config{
+		Context: &suggest.PackedContext{
+			Env: []string{
+				"GOROOT=" + filepath.Join(root, "goroot"),
+				"GOPATH=" + filepath.Join(root, "gopath"),
+			},
+		},
+	}
+	defer suggest.RefreshImportablePackages()
+
+	tests := []struct {
+		prefix string
+		want   []suggest.PackageInfo
+	}{
+		{"fmt", []suggest.PackageInfo{
+			{ImportPath: "fmt", Name: "fmt", Standard: true},
+		}},
+		{"example.com/", []suggest.PackageInfo{
+			{ImportPath: "example.com/Bar", Name: "bar"},
+			{ImportPath: "example.com/Bar/sub", Name: "sub"},
+			{ImportPath: "example.com/fmtutil", Name: "fmtutil"},
+			{ImportPath: "example.com/foo", Name: "foo"},
+		}},
+		{"example.com/f", []suggest.PackageInfo{
+			{ImportPath: "example.com/fmtutil", Name: "fmtutil"},
+			{ImportPath: "example.com/foo", Name: "foo"},
+		}},
+		{"cmd", []suggest.PackageInfo{
+			{ImportPath: "cmd/tool", Name: "tool"},
+		}},
+		{"zzz", nil},
+	}
+	for _, test := range tests {
+		if got := cfg.ImportablePackages(test.prefix); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ImportablePackages(%q) = %v, want %v", test.prefix, got, test.want)
+		}
+	}
+
+	// The index is cached until refreshed.
+	path := filepath.Join(root, "gopath/src/example.com/new/new.go")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("package new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.ImportablePackages("example.com/new"); got != nil {
+		t.Errorf("got %v before refresh, want nil", got)
+	}
+	before := suggest.ImportableCacheStats()
+	suggest.RefreshImportablePackages()
+	if got := cfg.ImportablePackages("example.com/new"); len(got) != 1 {
+		t.Errorf("got %v after refresh, want example.com/new", got)
+	}
+	cfg.ImportablePackages("fmt")
+	after := suggest.ImportableCacheStats()
+	if hits, misses := after.Hits-before.Hits, after.Misses-before.Misses; hits != 1 || misses != 1 {
+		t.Errorf("got %d hits and %d misses after refresh, want 1 and 1", hits, misses)
+	}
+	if after.Packages != 8 {
+		t.Errorf("got %d cached packages, want 8", after.Packages)
+	}
+
+	// Import path candidates carry the package name.
+	src := "package p\n\nimport \"gopkg.in/ya"
+	candidates, n := cfg.Suggest(filepath.Join(root, "p.go"), []byte(src), len(src))
+	want := []suggest.Candidate{{Class: "import", PkgPath: "gopkg.in/yaml.v2", Name: "gopkg.in/yaml.v2", Type: "yaml"}}
+	if !reflect.DeepEqual(candidates, want) || n != len("gopkg.in/ya") {
+		t.Errorf("got %v, %d, want %v, %d", candidates, n, want, len("gopkg.in/ya"))
+	}
+
+	// GOMODCACHE replaces the module cache in GOPATH.
+	cfg.Context.Env = append(cfg.Context.Env, "GOMODCACHE="+filepath.Join(root, "modcache"))
+	wantMod := []suggest.PackageInfo{{ImportPath: "example.com/Baz", Name: "baz"}}
+	if got := cfg.ImportablePackages("example.com/B"); !reflect.DeepEqual(got, wantMod) {
+		t.Errorf("got %v with GOMODCACHE, want %v", got, wantMod)
+	}
+	if got := cfg.ImportablePackages("example.com/y"); got != nil {
+		t.Errorf("got %v from the download cache, want nil", got)
+	}
+}
+
+func TestInvalidatePackages(t *testing.T) {
+	root, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	write := func(name, src string) {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("goroot/src/fmt/print.go", "package fmt")
+	write("gopath/src/example.com/a/a.go", "package a")
+	write("gopath/src/example.com/b/b.go", "package b")
+	write("gopath/pkg/mod/example.com/m@v1.0.0/m.go", "package m")
+
+	cfg := suggest.Config{
+		Context: &suggest.PackedContext{
+			Env: []string{
+				"GOROOT=" + filepath.Join(root, "goroot"),
+				"GOPATH=" + filepath.Join(root, "gopath"),
+			},
+		},
+	}
+	defer suggest.RefreshImportablePackages()
+	cfg.ImportablePackages("")
+
+	// Only the invalidated paths are looked up again: the renamed a, the
+	// removed b and the added c. The added d isn't seen yet, and m is
+	// still found in the module cache.
+	write("gopath/src/example.com/a/a.go", "package renamed")
+	if err := os.RemoveAll(filepath.Join(root, "gopath/src/example.com/b")); err != nil {
+		t.Fatal(err)
+	}
+	write("gopath/src/example.com/c/c.go", "package c")
+	write("gopath/src/example.com/d/d.go", "package d")
+	suggest.InvalidatePackages([]string{"example.com/a", "example.com/b", "example.com/c", "example.com/m"})
+
+	want := []suggest.PackageInfo{
+		{ImportPath: "example.com/a", Name: "renamed"},
+		{ImportPath: "example.com/c", Name: "c"},
+		{ImportPath: "example.com/m", Name: "m"},
+		{ImportPath: "fmt", Name: "fmt", Standard: true},
+	}
+	before := suggest.ImportableCacheStats()
+	if got := cfg.ImportablePackages(""); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v after invalidating, want %v", got, want)
+	}
+	if after := suggest.ImportableCacheStats(); after.Misses != before.Misses {
+		t.Errorf("invalidating packages rebuilt the whole index")
+	}
+}
+
+func TestPathMatch(t *testing.T) {
+	root, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{
+		"goroot/src/path/path.go",
+		"goroot/src/path/filepath/path.go",
+		"goroot/src/profile/profile.go",
+		"gopath/src/example.com/filter/filter.go",
+		"gopath/src/example.com/lib/file/file.go",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		src := "package " + strings.TrimSuffix(filepath.Base(path), ".go")
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer suggest.RefreshImportablePackages()
+
+	tests := []struct {
+		mode    suggest.PathMatchMode
+		partial string
+		want    []string
+	}{
+		{suggest.PathMatchPrefix, "path/fil", []string{"path/filepath"}},
+		{suggest.PathMatchPrefix, "fil", nil},
+		{suggest.PathMatchPrefix, "p", []string{"path", "path/filepath", "profile"}},
+		{suggest.PathMatchSegment, "path/fil", []string{"path/filepath"}},
+		{suggest.PathMatchSegment, "fil", []string{"example.com/filter", "example.com/lib/file", "path/filepath"}},
+		{suggest.PathMatchSegment, "lib/fi", []string{"example.com/lib/file"}},
+		{suggest.PathMatchSegment, "p", []string{"path", "path/filepath", "profile"}},
+		{suggest.PathMatchSegment, "com/fil", nil},
+	}
+	for _, test := range tests {
+		cfg := suggest.Config{
+			Context: &suggest.PackedContext{
+				Env: []string{
+					"GOROOT=" + filepath.Join(root, "goroot"),
+					"GOPATH=" + filepath.Join(root, "gopath"),
+				},
+			},
+			PathMatch: test.mode,
+		}
+		src := "package p\n\nimport \"" + test.partial
+		candidates, n := cfg.Suggest(filepath.Join(root, "p.go"), []byte(src), len(src))
+		var got []string
+		for _, c := range candidates {
+			got = append(got, c.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) || (got != nil && n != len(test.partial)) {
+			t.Errorf("%s match of %q: got %v, %d, want %v, %d", test.mode, test.partial, got, n, test.want, len(test.partial))
+		}
+	}
+}
+
+func TestTypeMembers(t *testing.T) {
+	cfg := suggest.Config{Context: &suggest.PackedContext{}}
+
+	var got []string
+	for _, c := range cfg.TypeMembers("image", "Point") {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"func Add(q image.Point) image.Point",
+		"func Div(k int) image.Point",
+		"func Eq(q image.Point) bool",
+		"func In(r image.Rectangle) bool",
+		"func Mod(r image.Rectangle) image.Point",
+		"func Mul(k int) image.Point",
+		"func String() string",
+		"func Sub(q image.Point) image.Point",
+		"var X int",
+		"var Y int",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TypeMembers(image, Point):\ngot  %q\nwant %q", got, want)
+	}
+
+	if got := cfg.TypeMembers("image", "ZP"); got != nil {
+		t.Errorf("TypeMembers of a non-type = %v, want nil", got)
+	}
+}
+
+func TestFileSymbols(t *testing.T) {
+	const src = `package p
+
+import "io"
+
+type Server struct {
+	w io.Writer
+}
+
+type ID int
+
+const (
+	A ID = iota
+	B
+)
+
+var _, count = 0, 1
+
+func (s *Server) Start(addr string) error { return nil }
+
+func New(w io.Writer) *Server {
+	var local int
+	return &Server{w: w}
+}
+`
+	var cfg suggest.Config
+	var got []string
+	for _, c := range cfg.FileSymbols("p.go", []byte(src)) {
+		got = append(got, c.Signature())
+		if c.Pos == nil || c.Pos.Line == 0 {
+			t.Errorf("no position for %s", c.Name)
+		}
+	}
+	want := []string{
+		"type Server struct",
+		"type ID int",
+		"const A ID",
+		"const B ",
+		"var count ",
+		"func (s *Server) Start(addr string) error",
+		"func New(w io.Writer) *Server",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := cfg.FileSymbols("p.go", []byte("not go")); got != nil {
+		t.Errorf("got %v for invalid source, want nil", got)
+	}
+
+	// The package path is the import path of the file's directory, not
+	// its package name.
+	root, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for name, src := range map[string]string{
+		"m/go.mod":                   "module example.com/m\n\ngo 1.14\n",
+		"gopath/src/example.com/g/x": "",
+		"none/x":                     "",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.Context = &suggest.PackedContext{Env: []string{"GOPATH=" + filepath.Join(root, "gopath")}}
+	for file, want := range map[string]string{
+		"m/sub/p.go":                    "example.com/m/sub",
+		"m/p.go":                        "example.com/m",
+		"gopath/src/example.com/g/p.go": "example.com/g",
+		"none/p.go":                     "",
+	} {
+		syms := cfg.FileSymbols(filepath.Join(root, filepath.FromSlash(file)), []byte(src))
+		if len(syms) == 0 || syms[0].PkgPath != want {
+			t.Errorf("got %v for %s, want package path %q", syms, file, want)
+		}
+	}
+}
+
+func TestNodePath(t *testing.T) {
+	const src = "package p\n\nfunc f(x int) {\n\tif x > 0 {\n\t\tprintln(x)\n\t}\n}\n"
+	cursor := strings.Index(src, "(x)") + 2
+
+	var cfg suggest.Config
+	var kinds []string
+	path := cfg.NodePath("p.go", []byte(src), cursor)
+	for _, n := range path {
+		kinds = append(kinds, n.Kind)
+	}
+	want := []string{"File", "FuncDecl", "BlockStmt", "IfStmt", "BlockStmt", "ExprStmt", "CallExpr", "Ident"}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("got %v, want %v", kinds, want)
+	}
+	if n := path[len(path)-1]; n.Start != cursor-1 || n.End != cursor {
+		t.Errorf("got x at [%d, %d), want [%d, %d)", n.Start, n.End, cursor-1, cursor)
+	}
+	if n := path[3]; src[n.Start:n.End] != "if x > 0 {\n\t\tprintln(x)\n\t}" {
+		t.Errorf("got if statement %q", src[n.Start:n.End])
+	}
+
+	// Incomplete code still yields the enclosing nodes.
+	const partial = "package p\n\nfunc f() {\n\tfor {\n\t\tx."
+	kinds = nil
+	for _, n := range cfg.NodePath("p.go", []byte(partial), len(partial)) {
+		kinds = append(kinds, n.Kind)
+	}
+	if len(kinds) < 4 || kinds[3] != "ForStmt" {
+		t.Errorf("got %v for incomplete code, want File, FuncDecl, BlockStmt, ForStmt, ...", kinds)
+	}
+
+	if got := cfg.NodePath("p.go", []byte(src), len(src)+1); got != nil {
+		t.Errorf("got %v for a cursor out of range, want nil", got)
+	}
+}
+
+func TestPackageClause(t *testing.T) {
+	root, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "go-widget")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg suggest.Config
+	complete := func(name, src string) []string {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		candidates, _ := cfg.Suggest(filename, []byte(src), len(src))
+		var names []string
+		for _, c := range candidates {
+			names = append(names, c.String())
+		}
+		return names
+	}
+
+	if got, want := complete("a.go", "package "), []string{"package widget ", "package main "}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in an empty directory, want %v", got, want)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package widgets\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := complete("b.go", "package "), []string{"package widgets ", "package widget ", "package main "}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := complete("b.go", "package wi"), []string{"package widgets ", "package widget "}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v for a partial name, want %v", got, want)
+	}
+	if got, want := complete("b_test.go", "package widgets_"), []string{"package widgets_test "}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in a test file, want %v", got, want)
+	}
+}
+
+func TestImplementers(t *testing.T) {
+	cfg := suggest.Config{Context: &suggest.PackedContext{}}
+
+	got := cfg.Implementers("sort", "Interface")
+	want := []suggest.TypeInfo{
+		{Name: "Float64Slice", PkgPath: "sort"},
+		{Name: "IntSlice", PkgPath: "sort"},
+		{Name: "StringSlice", PkgPath: "sort"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Implementers(sort, Interface) = %v, want %v", got, want)
+	}
+
+	// Types from direct imports are included.
+	found := false
+	for _, ti := range cfg.Implementers("image/draw", "Image") {
+		if ti.Name == "Uniform" {
+			t.Errorf("Implementers(image/draw, Image) includes image.Uniform, which has no Set method")
+		}
+		if ti == (suggest.TypeInfo{Name: "RGBA", PkgPath: "image", Pointer: true}) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Implementers(image/draw, Image) is missing *image.RGBA")
+	}
+
+	if got := cfg.Implementers("sort", "IntSlice"); got != nil {
+		t.Errorf("Implementers of a non-interface = %v, want nil", got)
+	}
+}
+
+func TestDeduceCursorInfo(t *testing.T) {
+	tests := []struct {
+		src  string
+		want suggest.CursorInfo
+	}{
+		{`fmt.Errorf("failed: %@", err)`, suggest.CursorInfo{Kind: "formatString", Expr: "fmt.Errorf"}},
+		{`fmt.Fprintf(w, "%@`, suggest.CursorInfo{Kind: "formatString", Expr: "fmt.Fprintf"}},
+		{`t.Logf(f(x), "%@")`, suggest.CursorInfo{Kind: "formatString", Expr: "t.Logf"}},
+		{`log.Printf("%@")`, suggest.CursorInfo{Kind: "formatString", Expr: "log.Printf"}},
+		{`logf("%@")`, suggest.CursorInfo{Kind: "unknown"}},
+		{`reflect.TypeOf("%@")`, suggest.CursorInfo{Kind: "unknown"}},
+		{`setIf("%@")`, suggest.CursorInfo{Kind: "unknown"}},
+		{`fmt.Println("%@")`, suggest.CursorInfo{Kind: "unknown"}},
+		{`fmt.Printf("%d", "%@")`, suggest.CursorInfo{Kind: "unknown"}},
+		{`fmt.Printf("%d"@)`, suggest.CursorInfo{Kind: "unknown"}},
+		{`fmt.Pri@`, suggest.CursorInfo{Kind: "select", Expr: "fmt", Partial: "Pri"}},
+		{`x := map[string]Handler{"x": h.@`, suggest.CursorInfo{Kind: "select", Expr: "h"}},
+		{"x := a.\n\t@", suggest.CursorInfo{Kind: "select", Expr: "a"}},
+		{"x := a.b.\n\t\tC@", suggest.CursorInfo{Kind: "select", Expr: "a . b", Partial: "C"}},
+		{"x := a\n\t.@", suggest.CursorInfo{Kind: "select", Expr: "a"}},
+		{"fmt.Println((a + b).@", suggest.CursorInfo{Kind: "select", Expr: "( a + b )"}},
+		{"x := (cond && ok || !done).S@", suggest.CursorInfo{Kind: "select", Expr: "( cond && ok || ! done )", Partial: "S"}},
+		{"x := [3]Point{}[0].@", suggest.CursorInfo{Kind: "select", Expr: "[ 3 ] Point { } [ 0 ]"}},
+		{"x := [...]Point{{1, 2}}[i].X@", suggest.CursorInfo{Kind: "select", Expr: "[ ... ] Point { { 1 , 2 } } [ i ]", Partial: "X"}},
+		{`x := map[string]Point{}["a"].@`, suggest.CursorInfo{Kind: "select", Expr: `map [ string ] Point { } [ "a" ]`}},
+		{"x := []geom.Point{}[0].@", suggest.CursorInfo{Kind: "select", Expr: "[ ] geom . Point { } [ 0 ]"}},
+		{`x := map[string]geom.Point{}["a"].X@`, suggest.CursorInfo{Kind: "select", Expr: `map [ string ] geom . Point { } [ "a" ]`, Partial: "X"}},
+		{"x := a[i]b.c.@", suggest.CursorInfo{Kind: "select", Expr: "b . c"}},
+		{"x := a[i]b.@", suggest.CursorInfo{Kind: "select", Expr: "b"}},
+		{"x := Repo[User]{}.A@", suggest.CursorInfo{Kind: "select", Expr: "Repo [ User ] { }", Partial: "A"}},
+		{"x := Map[string, int]{}.@", suggest.CursorInfo{Kind: "select", Expr: "Map [ string , int ] { }"}},
+		{"var x @", suggest.CursorInfo{Kind: "varType"}},
+		{"package @", suggest.CursorInfo{Kind: "packageClause"}},
+		{"p := new(@", suggest.CursorInfo{Kind: "typeArgument", Expr: "new"}},
+		{"m := make(map[string]int, len(x)); p := new(By@", suggest.CursorInfo{Kind: "typeArgument", Expr: "new", Partial: "By"}},
+		{"m := make(@", suggest.CursorInfo{Kind: "typeArgument", Expr: "make"}},
+		{"p := pool.new(@", suggest.CursorInfo{Kind: "unknown"}},
+		{"// Package p does things.\npackage p@", suggest.CursorInfo{Kind: "packageClause", Partial: "p"}},
+		{"var (\n\ta = f(1)\n\tb, c Str@\n)", suggest.CursorInfo{Kind: "varType", Partial: "Str"}},
+		{"var (\n\tx @", suggest.CursorInfo{Kind: "varType"}},
+		{"func f() {\n\tx @", suggest.CursorInfo{Kind: "unknown"}},
+		{"f(func() {\n\tvar y int\n\tx Str@", suggest.CursorInfo{Kind: "unknown", Partial: "Str"}},
+		{"func f(a, b @", suggest.CursorInfo{Kind: "unknown"}},
+		{"total += fo@", suggest.CursorInfo{Kind: "compoundAssign", Expr: "total", Partial: "fo"}},
+		{"s.n[i] &^= @", suggest.CursorInfo{Kind: "compoundAssign", Expr: "s . n [ i ]"}},
+		{"x <<= @", suggest.CursorInfo{Kind: "unknown"}},
+		{`s.logger.With("addr", s.addr).I@`, suggest.CursorInfo{Kind: "select", Expr: `s . logger . With ( "addr" , s . addr )`, Partial: "I"}},
+		{`data[1:3].@`, suggest.CursorInfo{Kind: "select", Expr: "data [ 1 : 3 ]"}},
+		{`data[:n+1].S@`, suggest.CursorInfo{Kind: "select", Expr: "data [ : n + 1 ]", Partial: "S"}},
+		{`x := (*Foo)(unsafe.Pointer(p)).@`, suggest.CursorInfo{Kind: "select", Expr: "( * Foo ) ( unsafe . Pointer ( p ) )"}},
+
+		// Illegal tokens near the cursor.
+		{`x := a$@`, suggest.CursorInfo{Kind: "unknown"}},
+		{`x := a#@`, suggest.CursorInfo{Kind: "unknown"}},
+		{`x := $fo@`, suggest.CursorInfo{Kind: "unknown", Partial: "fo"}},
+		{`x := a.b$.@`, suggest.CursorInfo{Kind: "unknown"}},
+		{`x := a.b?.c@`, suggest.CursorInfo{Kind: "unknown"}},
+		{`x := $a.b.@`, suggest.CursorInfo{Kind: "select", Expr: "a . b"}},
+		{`x := m[$].@`, suggest.CursorInfo{Kind: "unknown"}},
+		{`x := map[string]T{$: 1, @`, suggest.CursorInfo{Kind: "compositeLiteral", Expr: "map [ string ] T"}},
+		{`x := map[$]T{k: 1, @`, suggest.CursorInfo{Kind: "compositeLiteral"}},
+		{`x := Config{Level: @`, suggest.CursorInfo{Kind: "comparison", Expr: "Config { } . Level"}},
+		{`x := &log.Config{Out: w, Level: De@`, suggest.CursorInfo{Kind: "comparison", Expr: "log . Config { } . Level", Partial: "De"}},
+		{`x := []T{{Level: @`, suggest.CursorInfo{Kind: "unknown"}},
+
+		// Beginning of statements.
+		{"func f() {\n\tfo@", suggest.CursorInfo{Kind: "compositeLiteral", Partial: "fo", StatementStart: true}},
+		{"func f() {@", suggest.CursorInfo{Kind: "compositeLiteral", StatementStart: true}},
+		{"func f() {\n\tx := 1\n\t@", suggest.CursorInfo{Kind: "unknown", StatementStart: true}},
+		{"func f() {\n\tx := 1; @", suggest.CursorInfo{Kind: "unknown", StatementStart: true}},
+		{"func f() {\n\tif x {\n\t}\n\tre@", suggest.CursorInfo{Kind: "unknown", Partial: "re", StatementStart: true}},
+		{"func f() {\n\tx := fo@", suggest.CursorInfo{Kind: "unknown", Partial: "fo"}},
+		{"func f() {\n\tx := 1 @", suggest.CursorInfo{Kind: "unknown"}},
+		{"func f() {\n\tx.fo@", suggest.CursorInfo{Kind: "select", Expr: "x", Partial: "fo"}},
+		{"func f() {\n\tdefer a.b.Un@", suggest.CursorInfo{Kind: "select", Expr: "a . b", Partial: "Un"}},
+		{"func f() {\n\tgo a.b().@", suggest.CursorInfo{Kind: "select", Expr: "a . b ( )"}},
+		{"import \"fmt\"\n\nfunc f() {\n\tfmt.Pri@", suggest.CursorInfo{Kind: "select", Expr: "fmt", Partial: "Pri"}},
+
+		// Type arguments.
+		{`x := NewCache[string, @`, suggest.CursorInfo{Kind: "typeArgument", Expr: "NewCache"}},
+		{`x := lib.Map[K, []V, ma@]()`, suggest.CursorInfo{Kind: "typeArgument", Expr: "lib . Map", Partial: "ma"}},
+		{`x := m[a, @`, suggest.CursorInfo{Kind: "typeArgument", Expr: "m"}},
+		{`x := m[@`, suggest.CursorInfo{Kind: "unknown"}},
+		{`type IntList = List[@`, suggest.CursorInfo{Kind: "typeArgument", Expr: "List"}},
+		{`type IntTree tree.Tree[I@]`, suggest.CursorInfo{Kind: "typeArgument", Expr: "tree . Tree", Partial: "I"}},
+		{"type (\n\tA int\n\tB = List[@", suggest.CursorInfo{Kind: "typeArgument", Expr: "List"}},
+		{`type List[@`, suggest.CursorInfo{Kind: "unknown"}},
+		{"func f() {\n\tx = list[@", suggest.CursorInfo{Kind: "unknown"}},
+		{`x := []T{a, @`, suggest.CursorInfo{Kind: "compositeLiteral", Expr: "[ ] T"}},
+
+		// Composite literals with elided types.
+		{`x := map[string]T{"a": {Fi@`, suggest.CursorInfo{Kind: "elementLiteral", Expr: "map [ string ] T", Partial: "Fi", StatementStart: true}},
+		{`x := map[string]T{"a": {A: 1}, "b": {A: 2, @`, suggest.CursorInfo{Kind: "elementLiteral", Expr: "map [ string ] T"}},
+		{`x := []lib.T{{}, {@`, suggest.CursorInfo{Kind: "elementLiteral", Expr: "[ ] lib . T", StatementStart: true}},
+		{`x := []*T{ {Va@`, suggest.CursorInfo{Kind: "elementLiteral", Expr: "[ ] * T", Partial: "Va", StatementStart: true}},
+
+		// Interface elements.
+		{"type T interface {\n\tio.@", suggest.CursorInfo{Kind: "interfaceEmbed", Expr: "io"}},
+		{"type T interface {\n\tClose()\n\tio.R@", suggest.CursorInfo{Kind: "interfaceEmbed", Expr: "io", Partial: "R"}},
+		{"type T interface { R@", suggest.CursorInfo{Kind: "interfaceEmbed", Partial: "R", StatementStart: true}},
+		{"type T struct {\n\tio.@", suggest.CursorInfo{Kind: "select", Expr: "io"}},
+		{"func f() {\n\tg()\n\tio.@", suggest.CursorInfo{Kind: "select", Expr: "io"}},
+
+		// Branch targets.
+		{"for {\n\tbreak @", suggest.CursorInfo{Kind: "label", Expr: "break"}},
+		{"for {\n\tcontinue Ou@", suggest.CursorInfo{Kind: "label", Expr: "continue", Partial: "Ou"}},
+		{"for {\n\tf()\n\tbreak@", suggest.CursorInfo{Kind: "unknown", Partial: "break", StatementStart: true}},
+
+		// Import paths.
+		{`import "encoding/js@`, suggest.CursorInfo{Kind: "importPath", Partial: "encoding/js"}},
+		{`import "encoding/js@"`, suggest.CursorInfo{Kind: "importPath", Partial: "encoding/js"}},
+		{"import (\n\t\"fmt\"\n\tj \"enc@\"\n)", suggest.CursorInfo{Kind: "importPath", Partial: "enc"}},
+		{"import (\n\t. \"fmt\"\n\t\"@\"\n)", suggest.CursorInfo{Kind: "importPath"}},
+		{`import @"fmt"`, suggest.CursorInfo{Kind: "unknown"}},
+		{`x := g("enc@")`, suggest.CursorInfo{Kind: "unknown"}},
+
+		// Method receivers.
+		{"package p\n\nfunc (@ *Server) Name() {}", suggest.CursorInfo{Kind: "receiver", Expr: "*Server"}},
+		{"x := 1\nfunc (s@rv Server) Name() {}", suggest.CursorInfo{Kind: "receiver", Expr: "Server", Partial: "s"}},
+		{"type T struct {\n\tA int `json:\"a,@\"`\n}", suggest.CursorInfo{Kind: "structTag", Expr: "json"}},
+		{"type T struct {\n\tA []int `json:\"a\" validate:\"min=1,re@", suggest.CursorInfo{Kind: "structTag", Expr: "validate", Partial: "re"}},
+		{"type T struct {\n\tA struct{} `valid@`\n}", suggest.CursorInfo{Kind: "unknown"}},
+		{"x := struct{ A string }{\"a@\"}", suggest.CursorInfo{Kind: "compositeLiteral", Expr: "struct { A string }"}},
+		{"package p\n\nfunc (@) Name() {}", suggest.CursorInfo{Kind: "unknown"}},
+		{"x := func(@ int) {}", suggest.CursorInfo{Kind: "unknown"}},
+
+		// Case clauses.
+		{"switch c {\ncase @", suggest.CursorInfo{Kind: "comparison", Expr: "c"}},
+		{"switch c := f(); c.kind {\ncase Red, Gr@", suggest.CursorInfo{Kind: "comparison", Expr: "c . kind", Partial: "Gr"}},
+		{"switch x := f(func() {}); x[0] {\ncase A:\n\tg()\ncase T{1, 2}, @", suggest.CursorInfo{Kind: "comparison", Expr: "x [ 0 ]"}},
+		{"switch v := x.(type) {\ncase St@", suggest.CursorInfo{Kind: "typeAssertion", Partial: "St"}},
+		{"switch x.(type) {\ncase St@", suggest.CursorInfo{Kind: "typeAssertion", Partial: "St"}},
+		{"switch y := f(); v := y.(type) {\ncase int, @", suggest.CursorInfo{Kind: "typeAssertion"}},
+		{"switch v := m[k].(type) {\ncase int:\n\tg(v)\ncase *T, @", suggest.CursorInfo{Kind: "typeAssertion"}},
+		{"switch {\ncase @", suggest.CursorInfo{Kind: "unknown"}},
+		{"select {\ncase @", suggest.CursorInfo{Kind: "unknown"}},
+
+		// Build constraints.
+		{"//go:build li@", suggest.CursorInfo{Kind: "buildConstraint", Partial: "li"}},
+		{"//go:build linux && !arm@\n\npackage p", suggest.CursorInfo{Kind: "buildConstraint", Partial: "arm"}},
+		{"// +build @", suggest.CursorInfo{Kind: "buildConstraint"}},
+		{"//go:build go1.2@", suggest.CursorInfo{Kind: "buildConstraint", Partial: "go1.2"}},
+		{"// go:build li@", suggest.CursorInfo{Kind: "unknown"}},
+		{"//go:build@", suggest.CursorInfo{Kind: "unknown"}},
+	}
+	for _, test := range tests {
+		cursor := strings.IndexByte(test.src, '@')
+		src := test.src[:cursor] + test.src[cursor+1:]
+		if got := suggest.DeduceCursorInfo([]byte(src), cursor); got != test.want {
+			t.Errorf("DeduceCursorInfo(%q) = %+v, want %+v", test.src, got, test.want)
+		}
+	}
+
+	// Cursors outside of the file.
+	const src = "//go:build linux\n\npackage p\n\nvar x = a.b"
+	for _, cursor := range []int{-1, len(src) + 1, len(src) + 100} {
+		if got := suggest.DeduceCursorInfo([]byte(src), cursor); got != (suggest.CursorInfo{Kind: "unknown"}) {
+			t.Errorf("DeduceCursorInfo at %d = %+v, want unknown", cursor, got)
+		}
+	}
+
+	// Further printf-like functions, by name or qualified name.
+	cfg := suggest.Config{PrintfFuncs: []string{"logf", "klog.Infof"}}
+	printfTests := []struct {
+		src  string
+		want suggest.CursorInfo
+	}{
+		{`logf("%@")`, suggest.CursorInfo{Kind: "formatString", Expr: "logf"}},
+		{`s.logf("%@")`, suggest.CursorInfo{Kind: "formatString", Expr: "s.logf"}},
+		{`klog.Infof("%@")`, suggest.CursorInfo{Kind: "formatString", Expr: "klog.Infof"}},
+		{`glog.Infof("%@")`, suggest.CursorInfo{Kind: "unknown"}},
+		{`fmt.Sprintf("%@")`, suggest.CursorInfo{Kind: "formatString", Expr: "fmt.Sprintf"}},
+	}
+	for _, test := range printfTests {
+		cursor := strings.IndexByte(test.src, '@')
+		src := test.src[:cursor] + test.src[cursor+1:]
+		if got := cfg.DeduceCursorInfo([]byte(src), cursor); got != test.want {
+			t.Errorf("DeduceCursorInfo(%q) with %v = %+v, want %+v", test.src, cfg.PrintfFuncs, got, test.want)
+		}
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	const src = `package p
+
+import "fmt" // comment
+
+/* block
+comment */
+type T struct{ a, b int }
+
+func f(t *T) string {
+	s := "str;ing"
+	for i := range []int{1, 2} { fmt.Println(i, t.a) }
+	return fmt.Sprintf("%d", t.b) + s
+}
+`
+	edits := []func(string) string{
+		func(s string) string { return s },
+		func(s string) string { return strings.Replace(s, "t.a", "t.", 1) },
+		func(s string) string { return strings.Replace(s, "comment */", "comment", 1) },
+		func(s string) string { return strings.Replace(s, "// comment", "", 1) },
+		func(s string) string { return strings.Replace(s, `"str;ing"`, `"str`, 1) },
+		func(s string) string { return strings.Replace(s, "s := ", "s := t.", 1) },
+		func(s string) string { return s[:len(s)/2] },
+	}
+	for i, edit := range edits {
+		edited := edit(src)
+		for cursor := 0; cursor <= len(edited); cursor++ {
+			suggest.DeduceCursorInfo([]byte("package q"), 0)
+			want := suggest.DeduceCursorInfo([]byte(edited), cursor)
+
+			suggest.DeduceCursorInfo([]byte(src), len(src))
+			if got := suggest.DeduceCursorInfo([]byte(edited), cursor); got != want {
+				t.Errorf("edit %d, cursor %d: got %+v after scanning the original source, want %+v", i, cursor, got, want)
+			}
+		}
+	}
+}
+
+func TestTokenCacheFiles(t *testing.T) {
+	srcs := []string{
+		"package p\n\nvar alpha, beta int\n\nvar _ = al",
+		"package p\n\nvar gamma int\n\nvar _ = ga",
+	}
+	var filenames []string
+	for _, src := range srcs {
+		filename, cleanup := writeTempFile(t, src)
+		defer cleanup()
+		filenames = append(filenames, filename)
+	}
+
+	// Completing alternately in two files reuses all the tokens of each.
+	var scanned, reused int
+	cfg := suggest.Config{
+		Logf: func(format string, args ...interface{}) {
+			if strings.HasPrefix(format, "Tokens: ") {
+				scanned, reused = args[0].(int), args[1].(int)
+			}
+		},
+		LogLevel: suggest.LogTrace,
+		Context:  &suggest.PackedContext{},
+		Fast:     true,
+	}
+	for round := 0; round < 2; round++ {
+		for i, src := range srcs {
+			cfg.Suggest(filenames[i], []byte(src), len(src))
+			if round > 0 && reused != scanned {
+				t.Errorf("%s: reused %d of %d tokens, want all", filenames[i], reused, scanned)
+			}
+		}
+	}
+}
+
+func TestUniverseBuiltins(t *testing.T) {
+	const src = "package p\n\nvar _ = "
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+
+	cfg := suggest.Config{
+		Context: &suggest.PackedContext{},
+		Builtin: true,
+	}
+	candidates, _ := cfg.Suggest(filename, []byte(src), len(src))
+	got := make(map[string]string)
+	for _, c := range candidates {
+		got[c.Name] = c.Type
+	}
+	for _, name := range types.Universe.Names() {
+		obj := types.Universe.Lookup(name)
+		if _, ok := obj.(*types.Builtin); !ok {
+			continue
+		}
+		typ, ok := got[name]
+		if !ok {
+			t.Errorf("missing builtin %s", name)
+		} else if typ == "" {
+			t.Errorf("builtin %s has no type", name)
+		}
+	}
+	for _, name := range []string{"clear", "max", "min"} {
+		if types.Universe.Lookup(name) == nil {
+			continue // toolchain before Go 1.21
+		}
+		if _, ok := got[name]; !ok {
+			t.Errorf("missing builtin %s", name)
+		}
+	}
+}
+
+func TestDeclaredIn(t *testing.T) {
+	const src = `package p
+
+type Reader interface{ Read() }
+
+type ReadCloser interface {
+	Reader
+	Close()
+}
+
+type File struct{}
+
+func (File) Stat() {}
+
+func f(rc ReadCloser, f File) {
+	`
+	filename, cleanup := writeTempFile(t, src+"}\n")
+	defer cleanup()
+
+	cfg := suggest.Config{
+		Context:        &suggest.PackedContext{},
+		WithDeclaredIn: true,
+	}
+	candidates, _ := cfg.Suggest(filename, []byte(src+"rc.}\n"), len(src)+3)
+	got := make(map[string]string)
+	for _, c := range candidates {
+		got[c.Name] = c.DeclaredIn
+	}
+	want := map[string]string{"Close": "ReadCloser", "Read": "Reader"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	candidates, _ = cfg.Suggest(filename, []byte(src+"f.}\n"), len(src)+2)
+	if len(candidates) != 1 || candidates[0].DeclaredIn != "" {
+		t.Errorf("got %v, want one candidate without DeclaredIn", candidates)
+	}
+}
+
+func TestNoBuiltins(t *testing.T) {
+	const src = "package p\n\nfunc f(cap int) {\n\t_ = "
+	filename, cleanup := writeTempFile(t, src+"\n}\n")
+	defer cleanup()
+
+	cfg := suggest.Config{
+		Context: &suggest.PackedContext{},
+		Builtin: true,
+	}
+	names := func() map[string]string {
+		candidates, _ := cfg.Suggest(filename, []byte(src), len(src))
+		res := make(map[string]string)
+		for _, c := range candidates {
+			res[c.Name] = c.Class
+		}
+		return res
+	}
+
+	if got := names(); got["len"] == "" || got["append"] == "" {
+		t.Errorf("got %v, want builtins", got)
+	}
+
+	cfg.NoBuiltins = true
+	got := names()
+	for _, name := range []string{"len", "append", "int", "nil"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("got builtin %s with NoBuiltins", name)
+		}
+	}
+	// The parameter shadowing the builtin is kept.
+	if got["cap"] != "var" {
+		t.Errorf("got %v, want var cap", got)
+	}
+}
+
+func TestSuggestErrors(t *testing.T) {
+	const src = "package p\n\nfunc f() {\n\tx := undefined\n\tx.\n}\n"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+	cursor := strings.Index(src, "x.") + 2
+
+	cfg := suggest.Config{Context: &suggest.PackedContext{}}
+	for _, c := range []suggest.Config{cfg, {Context: &suggest.PackedContext{}, Fast: true}} {
+		for _, bad := range []int{-1, len(src) + 1} {
+			if _, _, err := c.SuggestWithError(filename, []byte(src), bad); err != suggest.ErrCursorOutOfRange {
+				t.Errorf("got %v at %d (fast: %v), want ErrCursorOutOfRange", err, bad, c.Fast)
+			}
+		}
+	}
+	missing := filepath.Join(filepath.Dir(filename), "missing.go")
+	if _, _, err := cfg.SuggestWithError(missing, []byte(src), cursor); err != suggest.ErrFileNotFound {
+		t.Errorf("got %v for missing file, want ErrFileNotFound", err)
+	}
+	_, _, err := cfg.SuggestWithError(filename, []byte(src), cursor)
+	if terr, ok := err.(*suggest.TypeCheckError); !ok || len(terr.Errors) == 0 {
+		t.Errorf("got %v for ill-typed package, want *TypeCheckError", err)
+	}
+
+	// Candidates are found despite the type errors.
+	const ok = "package p\n\nvar _ = undefined\n\nfunc Hello() {}\n\nvar _ = He"
+	if err := ioutil.WriteFile(filename, []byte(ok), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if res, _, err := cfg.SuggestWithError(filename, []byte(ok), len(ok)); len(res) != 1 || err != nil {
+		t.Errorf("got %v, %v, want Hello without error", res, err)
+	}
+}
+
+func TestSuggestContext(t *testing.T) {
+	const src = "package p\n\nfunc f(x int) {\n\tx\n}\n"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+
+	cfg := suggest.Config{Context: &suggest.PackedContext{}}
+	cursor := strings.Index(src, "\tx") + 2
+	if got, _, err := cfg.SuggestContext(context.Background(), filename, []byte(src), cursor); len(got) != 1 || err != nil {
+		t.Errorf("got %v, %v, want x", got, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got, _, err := cfg.SuggestContext(ctx, filename, []byte(src), cursor); got != nil || err != context.Canceled {
+		t.Errorf("got %v, %v after cancellation, want %v", got, err, context.Canceled)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	const src = "package p\n\nfunc f(x int) {\n\tx\n}\n"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+	cursor := strings.Index(src, "\tx") + 2
+
+	defer os.Setenv(suggest.LogLevelEnv, os.Getenv(suggest.LogLevelEnv))
+	os.Unsetenv(suggest.LogLevelEnv)
+
+	logged := func(level suggest.LogLevel) []string {
+		var formats []string
+		cfg := suggest.Config{
+			Logf:     func(format string, args ...interface{}) { formats = append(formats, format) },
+			LogLevel: level,
+			Context:  &suggest.PackedContext{},
+		}
+		cfg.Suggest(filename, []byte(src), cursor)
+		return formats
+	}
+	contains := func(formats []string, prefix string) bool {
+		for _, f := range formats {
+			if strings.HasPrefix(f, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if got := logged(""); len(got) != 0 {
+		t.Errorf("logged %q by default, want nothing", got)
+	}
+	if got := logged(suggest.LogOff); len(got) != 0 {
+		t.Errorf("logged %q at %s, want nothing", got, suggest.LogOff)
+	}
+	if got := logged(suggest.LogInfo); !contains(got, "Completed") || contains(got, "Context") {
+		t.Errorf("logged %q at %s, want the request summary only", got, suggest.LogInfo)
+	}
+	if got := logged(suggest.LogDebug); !contains(got, "Context") || contains(got, "Tokens") {
+		t.Errorf("logged %q at %s, want the context but not the tokens", got, suggest.LogDebug)
+	}
+	if got := logged(suggest.LogTrace); !contains(got, "Tokens") || !contains(got, "Context") {
+		t.Errorf("logged %q at %s, want the context and the tokens", got, suggest.LogTrace)
+	}
+
+	os.Setenv(suggest.LogLevelEnv, string(suggest.LogDebug))
+	if got := logged(""); !contains(got, "Context") {
+		t.Errorf("logged %q with %s=%s, want the context", got, suggest.LogLevelEnv, suggest.LogDebug)
+	}
+	if got := logged(suggest.LogOff); len(got) != 0 {
+		t.Errorf("logged %q at %s with %s set, want nothing", got, suggest.LogOff, suggest.LogLevelEnv)
+	}
+}
+
+func TestFastImportNames(t *testing.T) {
+	const src = "package p\n\nimport (\n\t\"github.com/go-chi/chi/v5\"\n\t\"gopkg.in/yaml.v2\"\n\t\"math/rand/v2\"\n)\n\nfunc f() {\n\t\n}\n"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+	cursor := strings.Index(src, "\t\n}") + 1
+
+	// Major version suffixes are not part of the package name.
+	cfg := suggest.Config{Context: &suggest.PackedContext{}, Fast: true}
+	for _, want := range []string{"chi", "yaml", "rand"} {
+		data := src[:cursor] + want[:2] + src[cursor:]
+		candidates, _ := cfg.Suggest(filename, []byte(data), cursor+2)
+		found := false
+		for _, c := range candidates {
+			if c.Class == "package" && c.Name == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("got %v for %s, want package %s", candidates, want[:2], want)
+		}
+	}
+}
+
+func TestFastPkgPath(t *testing.T) {
+	const src = "package p\n\nfunc Hello() {}\n\nvar _ = He"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+	dir := filepath.Dir(filename)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both modes report the import path of the package being edited.
+	for _, fast := range []bool{false, true} {
+		cfg := suggest.Config{Context: &suggest.PackedContext{Dir: dir}, Fast: fast}
+		candidates, _ := cfg.Suggest(filename, []byte(src), len(src))
+		if len(candidates) != 1 || candidates[0].PkgPath != "example.com/m" {
+			t.Errorf("got %v (fast: %v), want Hello in example.com/m", candidates, fast)
+		}
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	const src = "package p\n\nfunc f() {\n\tne\n}\n"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+	dir := filepath.Dir(filename)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other.go")
+	if err := ioutil.WriteFile(other, []byte("package p\n\nvar old int\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unsaved := filepath.Join(dir, "unsaved.go")
+	cursor := strings.Index(src, "ne") + 2
+
+	for _, fast := range []bool{false, true} {
+		cfg := suggest.Config{Context: &suggest.PackedContext{Dir: dir}, Fast: fast}
+		if got, _ := cfg.Suggest(filename, []byte(src), cursor); got != nil {
+			t.Errorf("fast=%v: got %v without overlay, want nil", fast, got)
+		}
+
+		cfg.Overlay = map[string][]byte{other: []byte("package p\n\nvar old, newName int\n")}
+		got, _ := cfg.Suggest(filename, []byte(src), cursor)
+		if len(got) != 1 || got[0].Name != "newName" {
+			t.Errorf("fast=%v: got %v with overlay, want newName", fast, got)
+		}
+
+		// Files that exist only in the overlay are part of the package.
+		cfg.Overlay[unsaved] = []byte("package p\n\nvar nextName int\n")
+		got, _ = cfg.Suggest(filename, []byte(src), cursor)
+		if len(got) != 2 || got[0].Name != "newName" || got[1].Name != "nextName" {
+			t.Errorf("fast=%v: got %v with unsaved file, want newName and nextName", fast, got)
+		}
+	}
+}
+
+func TestAdaptiveRanking(t *testing.T) {
+	const src = "package p\n\nvar alpha, alphabet, alpine int\n\nvar _ = al"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+	defer suggest.ResetAcceptances()
+
+	names := func(adaptive bool) []string {
+		cfg := suggest.Config{Context: &suggest.PackedContext{}, AdaptiveRanking: adaptive}
+		candidates, _ := cfg.Suggest(filename, []byte(src), len(src))
+		var names []string
+		for _, c := range candidates {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+	cfg := suggest.Config{Context: &suggest.PackedContext{}}
+	candidates, _ := cfg.Suggest(filename, []byte(src), len(src))
+	if len(candidates) == 0 {
+		t.Fatal("no candidates")
+	}
+	pkgPath := candidates[0].PkgPath
+
+	suggest.RecordAcceptance("alpine", pkgPath)
+	suggest.RecordAcceptance("alpha", "example.com/other")
+	if got, want := names(true), []string{"alpine", "alpha", "alphabet"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v after accepting alpine, want %v", got, want)
+	}
+	if got, want := names(false), []string{"alpha", "alphabet", "alpine"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v without AdaptiveRanking, want %v", got, want)
+	}
+
+	// Frequency wins over recency.
+	suggest.RecordAcceptance("alphabet", pkgPath)
+	suggest.RecordAcceptance("alphabet", pkgPath)
+	suggest.RecordAcceptance("alpha", pkgPath)
+	if got, want := names(true), []string{"alphabet", "alpha", "alpine"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v after accepting alphabet twice, want %v", got, want)
+	}
+}
+
+func TestSuggestFromPackage(t *testing.T) {
+	const src = "package p\n\nimport \"strings\"\n\nvar _ = strings.Repe"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.LoadSyntax}, "file="+filename)
+	if err != nil || len(pkgs) != 1 {
+		t.Fatalf("loading %s: %v, %v", filename, pkgs, err)
+	}
+
+	var cfg suggest.Config
+	candidates, n, err := cfg.SuggestFromPackage(pkgs[0], filename, []byte(src), len(src))
+	if len(candidates) != 1 || candidates[0].Name != "Repeat" || n != len("Repe") || err != nil {
+		t.Errorf("got %v, %d, %v, want Repeat, %d", candidates, n, err, len("Repe"))
+	}
+
+	other := filepath.Join(filepath.Dir(filename), "other.go")
+	if _, _, err := cfg.SuggestFromPackage(pkgs[0], other, []byte(src), len(src)); err == nil {
+		t.Errorf("got no error for a file outside the package")
+	}
+}
+
+func TestVendorMod(t *testing.T) {
+	root, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	const src = "package m\n\nimport \"example.com/dep\"\n\nvar _ = dep.V"
+	files := map[string]string{
+		"go.mod":                        "module example.com/m\n\ngo 1.14\n\nrequire example.com/dep v1.0.0\n",
+		"m.go":                          src,
+		"vendor/modules.txt":            "# example.com/dep v1.0.0\n## explicit\nexample.com/dep\n",
+		"vendor/example.com/dep/dep.go": "package dep\n\nfunc Vendored() {}\n",
+	}
+	for name, src := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, cfg := range []suggest.Config{{
+		Context: &suggest.PackedContext{Dir: root, BuildFlags: []string{"-mod=mod"}},
+		Mod:     "vendor",
+	}, {
+		Context: &suggest.PackedContext{Dir: root, Env: append(os.Environ(), "GOFLAGS=-mod=vendor", "GOPROXY=off")},
+	}} {
+		candidates, _ := cfg.Suggest(filepath.Join(root, "m.go"), []byte(src), len(src))
+		if len(candidates) != 1 || candidates[0].Name != "Vendored" {
+			t.Errorf("config %d: got %v, want the vendored dep.Vendored", i, candidates)
+		}
+	}
+}
+
+func TestInternal(t *testing.T) {
+	root, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	const src = "package m\n\nimport \"example.com/m/internal/ver\"\n\nvar Version = ver.S"
+	files := map[string]string{
+		"go.mod":              "module example.com/m\n\ngo 1.14\n",
+		"m.go":                src,
+		"internal/ver/ver.go": "package ver\n\nconst String = \"1.0\"\n",
+	}
+	for name, src := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := suggest.Config{Context: &suggest.PackedContext{Dir: root}}
+	candidates, _ := cfg.Suggest(filepath.Join(root, "m.go"), []byte(src), len(src))
+	if len(candidates) != 1 || !candidates[0].Internal {
+		t.Errorf("got %+v, want the internal ver.String", candidates)
+	}
+
+	candidates, _ = cfg.Suggest(filepath.Join(root, "m.go"), []byte(src), len(src)-len(" = ver.S"))
+	if len(candidates) != 1 || candidates[0].Internal {
+		t.Errorf("got %+v, want Version, not internal", candidates)
+	}
+
+	// The objects of the package being completed are not flagged, even
+	// if it is internal.
+	const verSrc = "package ver\n\nconst String = \"1.0\"\n\nvar _ = Str"
+	candidates, _ = cfg.Suggest(filepath.Join(root, "internal", "ver", "ver.go"), []byte(verSrc), len(verSrc))
+	if len(candidates) != 1 || candidates[0].Internal {
+		t.Errorf("got %+v, want String, not internal", candidates)
+	}
+}
+
+func TestViaInterface(t *testing.T) {
+	const src = `package p
+
+type Shape interface{ Area() float64 }
+
+type Square struct{ Side float64 }
+
+func (s Square) Area() float64 { return s.Side * s.Side }
+
+func f[T Shape](s Shape, sq Square, t T) {
+	`
+	filename, cleanup := writeTempFile(t, src+"}\n")
+	defer cleanup()
+
+	cfg := suggest.Config{Context: &suggest.PackedContext{}}
+	for _, test := range []struct {
+		expr string
+		want bool
+	}{
+		{"s", true},
+		{"sq", false},
+		{"t", false},
+	} {
+		data := src + test.expr + ".}\n"
+		candidates, _ := cfg.Suggest(filename, []byte(data), len(src)+len(test.expr)+1)
+		if len(candidates) == 0 {
+			t.Errorf("%s: no candidates", test.expr)
+		}
+		for _, c := range candidates {
+			if c.ViaInterface != test.want {
+				t.Errorf("%s.%s: got ViaInterface %v, want %v", test.expr, c.Name, c.ViaInterface, test.want)
+			}
+		}
+	}
+}
+
+func TestMaxImportDepth(t *testing.T) {
+	const src = "package p\n\nimport \"net/http\"\n\nfunc f(r *http.Request) {\n\tr.URL.Hos\n}\n"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+	urlCursor := strings.Index(src, "r.URL.Hos") + len("r.URL.Hos")
+	reqCursor := strings.Index(src, "r.URL") + len("r.UR")
+
+	names := func(depth, cursor int) []string {
+		cfg := suggest.Config{Context: &suggest.PackedContext{}, MaxImportDepth: depth}
+		candidates, _ := cfg.Suggest(filename, []byte(src), cursor)
+		var names []string
+		for _, c := range candidates {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	// Direct imports are loaded at depth 1, but net/url, where the type
+	// of Request.URL is declared, is only loaded at depth 2.
+	if got := names(1, reqCursor); !reflect.DeepEqual(got, []string{"URL"}) {
+		t.Errorf("depth 1: got %v for r.UR, want URL", got)
+	}
+	if got := names(1, urlCursor); got != nil {
+		t.Errorf("depth 1: got %v for r.URL.Hos, want nothing", got)
+	}
+	want := []string{"Hostname", "Host"}
+	for _, depth := range []int{0, 2} {
+		if got := names(depth, urlCursor); !reflect.DeepEqual(got, want) {
+			t.Errorf("depth %d: got %v for r.URL.Hos, want %v", depth, got, want)
+		}
+	}
+
+	// net/url is imported both directly and through net/http, but only
+	// type checked once, so its types are identical either way.
+	const both = "package p\n\nimport (\n\t\"net/http\"\n\t\"net/url\"\n)\n\nfunc f(r *http.Request) {\n\tvar u *url.URL = r.URL\n\tu.Zz\n}\n"
+	if err := ioutil.WriteFile(filename, []byte(both), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := suggest.Config{Context: &suggest.PackedContext{}, MaxImportDepth: 2}
+	cursor := strings.Index(both, "u.Zz") + len("u.Zz")
+	_, _, err := cfg.SuggestWithError(filename, []byte(both), cursor)
+	if terr, ok := err.(*suggest.TypeCheckError); !ok || len(terr.Errors) != 1 {
+		t.Errorf("depth 2: got %v, want only the error of u.Zz", err)
+	}
+}
+
+func TestSoftTypeErrors(t *testing.T) {
+	const src = "package main\n\nimport \"os\"\n\nfunc main() {\n\tunused := 1\n\tvar s struct{}\n\ts.\n}\n"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+	cursor := strings.Index(src, "s.") + 2
+
+	// Only the error of the incomplete selector is reported.
+	cfg := suggest.Config{Context: &suggest.PackedContext{}}
+	_, _, err := cfg.SuggestWithError(filename, []byte(src), cursor)
+	terr, ok := err.(*suggest.TypeCheckError)
+	if !ok {
+		t.Fatalf("got %v, want *TypeCheckError", err)
+	}
+	for _, e := range terr.Errors {
+		if strings.Contains(e.Msg, "not used") {
+			t.Errorf("got soft error %v", e)
+		}
+	}
+	if len(terr.Errors) != 1 {
+		t.Errorf("got errors %v, want only the selector error", terr.Errors)
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	// The ?? operator stands in for syntax newer than the parser.
+	const src = "package p\n\nimport \"strings\"\n\nfunc g(a, b *int) *int { return a ?? b }\n\nfunc f(s struct{}) {\n\t"
+	filename, cleanup := writeTempFile(t, src+"}\n")
+	defer cleanup()
+
+	// Candidates are still found after the syntax error.
+	cfg := suggest.Config{Context: &suggest.PackedContext{}}
+	data := src + "strings.Repe\n}\n"
+	candidates, _, err := cfg.SuggestWithError(filename, []byte(data), len(src+"strings.Repe"))
+	if len(candidates) != 1 || err != nil {
+		t.Errorf("got %v, %v, want strings.Repeat", candidates, err)
+	}
+
+	// Without candidates, the syntax error is reported.
+	data = src + "s.\n}\n"
+	_, _, err = cfg.SuggestWithError(filename, []byte(data), len(src+"s."))
+	serr, ok := err.(*suggest.SyntaxError)
+	if !ok {
+		t.Fatalf("got %v, want *SyntaxError", err)
+	}
+	if len(serr.Errors) == 0 || serr.Errors[0].Pos.Line != 5 {
+		t.Errorf("got errors %v, want them on line 5", serr.Errors)
+	}
+}
+
+func TestIgnoredFile(t *testing.T) {
+	filename, cleanup := writeTempFile(t, "package p\n\nvar general int\n")
+	defer cleanup()
+
+	const src = "//go:build ignore\n\npackage main\n\nimport \"strings\"\n\nvar generated = strings.Repeat(\"x\", 2)\n\nfunc main() {\n\t"
+	ignored := filepath.Join(filepath.Dir(filename), "gen.go")
+	if err := ioutil.WriteFile(ignored, []byte(src+"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := suggest.Config{Context: &suggest.PackedContext{}}
+	for _, test := range []struct {
+		expr string
+		want string
+	}{
+		{"gen", "generated"},
+		{"strings.Repe", "Repeat"},
+	} {
+		data := src + test.expr + "\n}\n"
+		candidates, _, err := cfg.SuggestWithError(ignored, []byte(data), len(src+test.expr))
+		if len(candidates) != 1 || candidates[0].Name != test.want || err != nil {
+			t.Errorf("%s: got %v, %v, want %s", test.expr, candidates, err, test.want)
+		}
+	}
+
+	// Its imports are loaded with the unsaved buffers of the overlay.
+	dir := filepath.Dir(filename)
+	lib := filepath.Join(dir, "lib", "lib.go")
+	if err := os.MkdirAll(filepath.Dir(lib), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(lib, []byte("package lib\n\nfunc Old() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const usesLib = "//go:build ignore\n\npackage main\n\nimport \"example.com/m/lib\"\n\nfunc main() {\n\tlib."
+	cfg = suggest.Config{
+		Context: &suggest.PackedContext{Dir: dir},
+		Overlay: map[string][]byte{lib: []byte("package lib\n\nfunc New() {}\n")},
+	}
+	candidates, _, err := cfg.SuggestWithError(ignored, []byte(usesLib+"\n}\n"), len(usesLib))
+	if len(candidates) != 1 || candidates[0].Name != "New" || err != nil {
+		t.Errorf("got %v, %v, want New from the overlay", candidates, err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	const src = `package p
+
+type Buffer struct{}
+
+func (b *Buffer) Write(p []byte) (n int, err error) { return 0, nil }
+
+type Writer interface{ Write(p []byte) (int, error) }
+
+func Fprint(w Writer, a ...interface{}) {}
+
+func f(b *Buffer, w Writer) {
+	`
+	filename, cleanup := writeTempFile(t, src+"}\n")
+	defer cleanup()
+
+	cfg := suggest.Config{
+		Context:        &suggest.PackedContext{},
+		WithSignatures: true,
+	}
+	for _, test := range []struct {
+		expr, want string
+	}{
+		{"b.", "func (b *Buffer) Write(p []byte) (n int, err error)"},
+		{"w.", "func (Writer) Write(p []byte) (int, error)"},
+		{"Fpr", "func Fprint(w Writer, a ...interface{})"},
+	} {
+		data := src + test.expr + "\n}\n"
+		candidates, _ := cfg.Suggest(filename, []byte(data), len(src)+len(test.expr))
+		if len(candidates) != 1 {
+			t.Errorf("%s: got %v, want one candidate", test.expr, candidates)
+			continue
+		}
+		if got := candidates[0].Signature(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestQualifyNames(t *testing.T) {
+	const src = `package p
+
+import "strings"
+
+type config struct{ Name string }
+
+type client struct{ cfg config }
+
+func f(c client) {
+	_ = strings.Repeat
+	`
+	filename, cleanup := writeTempFile(t, src+"}\n")
+	defer cleanup()
+
+	cfg := suggest.Config{
+		Context:      &suggest.PackedContext{},
+		QualifyNames: true,
+	}
+	for _, test := range []struct {
+		expr, want string
+		n          int
+	}{
+		{"strings.Repe", "strings.Repeat", len("strings.Repe")},
+		{"c.cfg.Na", "c.cfg.Name", len("c.cfg.Na")},
+		{"c.\n\t\tcfg.", "c.\n\t\tcfg.Name", len("c.\n\t\tcfg.")},
+		{"clie", "client", len("clie")},
+	} {
+		data := src + test.expr + "\n}\n"
+		candidates, n := cfg.Suggest(filename, []byte(data), len(src)+len(test.expr))
+		if len(candidates) != 1 || candidates[0].Name != test.want || n != test.n {
+			t.Errorf("%q: got %v, %d, want %s, %d", test.expr, candidates, n, test.want, test.n)
+		}
+	}
+}
+
+func TestPackageFilters(t *testing.T) {
+	const src = "package p\n\nimport . \"strings\"\n\nfunc Replay() {}\n\nvar _ = Rep"
+	filename, cleanup := writeTempFile(t, src)
+	defer cleanup()
+
+	all := []string{"Repeat", "Replace", "ReplaceAll", "Replay", "Replacer"}
+	for _, test := range []struct {
+		include, exclude []string
+		want             []string
+	}{
+		{nil, nil, all},
+		{nil, []string{"strings"}, []string{"Replay"}},
+		{[]string{"bytes"}, nil, []string{"Replay"}},
+		{[]string{"s*"}, nil, all},
+		{[]string{"s*"}, []string{"str*"}, []string{"Replay"}},
+		{[]string{"[invalid"}, nil, []string{"Replay"}},
+	} {
+		cfg := suggest.Config{
+			Context:         &suggest.PackedContext{},
+			IncludePackages: test.include,
+			ExcludePackages: test.exclude,
+		}
+		candidates, _ := cfg.Suggest(filename, []byte(src), len(src))
+		var got []string
+		for _, c := range candidates {
+			got = append(got, c.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("include %q, exclude %q: got %v, want %v", test.include, test.exclude, got, test.want)
+		}
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	const src = `package p
+
+import "errors"
+
+func Exported()  {}
+func unexported() {}
+
+var _ = errors.New
+
+func f() {
+	`
+	filename, cleanup := writeTempFile(t, src+"}\n")
+	defer cleanup()
+
+	names := func(cfg suggest.Config, expr string) []string {
+		data := src + expr + "\n}\n"
+		candidates, _ := cfg.Suggest(filename, []byte(data), len(src)+len(expr))
+		var res []string
+		for _, c := range candidates {
+			res = append(res, c.Name)
+		}
+		return res
+	}
+
+	tests := []struct {
+		visibility suggest.Visibility
+		local      []string // completing at the start of a statement
+		imported   []string // completing errors.New
+	}{
+		{suggest.VisibilityAuto, []string{"Exported", "f", "unexported", "errors"}, []string{"New"}},
+		{suggest.VisibilityExported, []string{"Exported", "errors"}, []string{"New"}},
+		{suggest.VisibilityUnexported, []string{"f", "unexported", "errors"}, nil},
+	}
+	for _, test := range tests {
+		cfg := suggest.Config{
+			Context:    &suggest.PackedContext{},
+			Visibility: test.visibility,
+		}
+		if got := names(cfg, ""); !reflect.DeepEqual(got, test.local) {
+			t.Errorf("%q: got %v, want %v", test.visibility, got, test.local)
+		}
+		if got := names(cfg, "errors.Ne"); !reflect.DeepEqual(got, test.imported) {
+			t.Errorf("%q: got %v for errors.Ne, want %v", test.visibility, got, test.imported)
+		}
+	}
+}
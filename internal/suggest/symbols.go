@@ -0,0 +1,106 @@
+package suggest
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+// FileSymbols returns the top-level declarations of the file filename,
+// in the order they are declared, e.g. for an outline of the file. If
+// src is nil, the file is read from disk. Methods are included, with
+// their receiver in Candidate.Recv, as they are declared in the file
+// of their declaration rather than that of their receiver type.
+//
+// The file is parsed but not type checked, so types are only reported
+// as written, and not at all for variables and constants declared
+// without one. Positions are always reported. Candidate.PkgPath is the
+// import path of the file's directory in its module or GOPATH, if any.
+func (c *Config) FileSymbols(filename string, src []byte) []Candidate {
+	fset := token.NewFileSet()
+	var source interface{}
+	if src != nil {
+		source = src
+	}
+	file, _ := parser.ParseFile(fset, filename, source, 0)
+	if file == nil || file.Name == nil {
+		return nil
+	}
+
+	pkgPath := c.dirImportPath(filepath.Dir(filename))
+	var res []Candidate
+	add := func(class string, name *ast.Ident, typ string) *Candidate {
+		if name.Name == "_" {
+			return nil
+		}
+		pos := fset.Position(name.Pos())
+		res = append(res, Candidate{
+			Class:   class,
+			PkgPath: pkgPath,
+			Name:    name.Name,
+			Type:    typ,
+			Pos:     &pos,
+		})
+		return &res[len(res)-1]
+	}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if cand := add("func", decl.Name, types.ExprString(decl.Type)); cand != nil && decl.Recv != nil {
+				cand.Recv = recvString(decl.Recv)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					add("type", spec.Name, typeExprString(spec.Type))
+				case *ast.ValueSpec:
+					class := "var"
+					if decl.Tok == token.CONST {
+						class = "const"
+					}
+					var typ string
+					if spec.Type != nil {
+						typ = types.ExprString(spec.Type)
+					}
+					for _, name := range spec.Names {
+						add(class, name, typ)
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+// recvString renders the receiver of a method declaration as in
+// Candidate.Recv, e.g. "(b *Buffer)".
+func recvString(recv *ast.FieldList) string {
+	if len(recv.List) == 0 {
+		return ""
+	}
+	field := recv.List[0]
+	var names []string
+	for _, name := range field.Names {
+		names = append(names, name.Name)
+	}
+	if len(names) == 0 {
+		return "(" + types.ExprString(field.Type) + ")"
+	}
+	return "(" + strings.Join(names, ", ") + " " + types.ExprString(field.Type) + ")"
+}
+
+// typeExprString renders the type of a type declaration as in the type
+// of "type" candidates, where struct and interface types are elided.
+func typeExprString(typ ast.Expr) string {
+	switch typ.(type) {
+	case *ast.StructType:
+		return "struct"
+	case *ast.InterfaceType:
+		return "interface"
+	}
+	return types.ExprString(typ)
+}
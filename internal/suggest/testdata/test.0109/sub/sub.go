@@ -0,0 +1,8 @@
+package sub
+
+// Greeting is exported.
+const Greeting = "hello"
+
+func Hello() string { return Greeting }
+
+func helper() {}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"net/rpc"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/stamblerre/gocode/internal/suggest"
@@ -56,6 +60,52 @@ func exitServer() {
 type Server struct {
 }
 
+// inflight holds the requests being computed by file name. While the
+// user types, a newer request for a file supersedes the previous ones,
+// whose results would be discarded anyway, so they are cancelled.
+var inflight struct {
+	mu       sync.Mutex
+	requests map[string]*inflightRequest
+}
+
+type inflightRequest struct {
+	cancel context.CancelFunc
+}
+
+// supersede cancels the request in flight for filename, if any, and
+// registers a new one. The returned function must be called once the
+// new request is done.
+func supersede(filename string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &inflightRequest{cancel: cancel}
+
+	inflight.mu.Lock()
+	if prev := inflight.requests[filename]; prev != nil {
+		prev.cancel()
+	}
+	if inflight.requests == nil {
+		inflight.requests = make(map[string]*inflightRequest)
+	}
+	inflight.requests[filename] = r
+	inflight.mu.Unlock()
+
+	return ctx, func() {
+		inflight.mu.Lock()
+		if inflight.requests[filename] == r {
+			delete(inflight.requests, filename)
+		}
+		inflight.mu.Unlock()
+		cancel()
+	}
+}
+
+// stats accumulates the requests served by the daemon.
+var stats struct {
+	mu       sync.Mutex
+	requests int
+	latency  time.Duration
+}
+
 type AutoCompleteRequest struct {
 	Filename   string
 	Data       []byte
@@ -64,6 +114,48 @@ type AutoCompleteRequest struct {
 	Source     bool
 	Builtin    bool
 	IgnoreCase bool
+	Fast       bool
+	// Overrides names the options among Builtin, IgnoreCase and Fast
+	// that override the defaults of the project's config file. The
+	// others are ignored. As gob omits false values, they can't be
+	// told apart from unset ones otherwise.
+	Overrides []string
+	Mod       string
+	// Overlay holds the unsaved buffers of other files by file name.
+	Overlay map[string][]byte
+}
+
+// config returns the configuration of the request: the defaults of
+// the project's config file, overridden by the request.
+func (req *AutoCompleteRequest) config() suggest.Config {
+	var cfg suggest.Config
+	if req.Filename != "" {
+		defaults, path, err := suggest.LoadConfigFile(filepath.Dir(req.Filename))
+		if err != nil {
+			log.Printf("Failed to load config file: %v\n", err)
+		} else {
+			cfg = defaults
+			if path != "" && *g_debug {
+				log.Printf("Loaded config file: %s\n", path)
+			}
+		}
+	}
+	cfg.Context = req.Context
+	for _, name := range req.Overrides {
+		switch name {
+		case "Builtin":
+			cfg.Builtin = req.Builtin
+		case "IgnoreCase":
+			cfg.IgnoreCase = req.IgnoreCase
+		case "Fast":
+			cfg.Fast = req.Fast
+		}
+	}
+	if req.Mod != "" {
+		cfg.Mod = req.Mod
+	}
+	cfg.Overlay = req.Overlay
+	return cfg
 }
 
 type AutoCompleteReply struct {
@@ -86,27 +178,48 @@ func (s *Server) AutoComplete(req *AutoCompleteRequest, res *AutoCompleteReply)
 		var buf bytes.Buffer
 		log.Printf("Got autocompletion request for '%s'\n", req.Filename)
 		log.Printf("Cursor at: %d\n", req.Cursor)
-		buf.WriteString("-------------------------------------------------------\n")
-		buf.Write(req.Data[:req.Cursor])
-		buf.WriteString("#")
-		buf.Write(req.Data[req.Cursor:])
-		log.Print(buf.String())
-		log.Println("-------------------------------------------------------")
+		if req.Cursor >= 0 && req.Cursor <= len(req.Data) {
+			buf.WriteString("-------------------------------------------------------\n")
+			buf.Write(req.Data[:req.Cursor])
+			buf.WriteString("#")
+			buf.Write(req.Data[req.Cursor:])
+			log.Print(buf.String())
+			log.Println("-------------------------------------------------------")
+		}
 	}
 	now := time.Now()
-	cfg := suggest.Config{
-		Context:    req.Context,
-		Builtin:    req.Builtin,
-		IgnoreCase: req.IgnoreCase,
-	}
+	cfg := req.config()
 	if *g_debug {
 		cfg.Logf = log.Printf
+		if cfg.LogLevel == "" && os.Getenv(suggest.LogLevelEnv) == "" {
+			cfg.LogLevel = suggest.LogDebug
+		}
+		cfg.Metrics = func(m suggest.Metrics) {
+			log.Printf("Load: %v (parse: %v), collect: %v\n", m.Load, m.Parse, m.Collect)
+		}
+	}
+	ctx := context.Background()
+	if req.Filename != "" {
+		var done func()
+		ctx, done = supersede(req.Filename)
+		defer done()
+	}
+	candidates, d, err := cfg.SuggestContext(ctx, req.Filename, req.Data, req.Cursor)
+	if err != nil && *g_debug {
+		if err == context.Canceled {
+			log.Printf("Superseded by a newer request\n")
+		} else {
+			log.Printf("No candidates: %v\n", err)
+		}
 	}
-	candidates, d := cfg.Suggest(req.Filename, req.Data, req.Cursor)
 	if candidates == nil {
 		candidates = []suggest.Candidate{}
 	}
 	elapsed := time.Since(now)
+	stats.mu.Lock()
+	stats.requests++
+	stats.latency += elapsed
+	stats.mu.Unlock()
 	if *g_debug {
 		log.Printf("Elapsed duration: %v\n", elapsed)
 		log.Printf("Offset: %d\n", res.Len)
@@ -121,6 +234,90 @@ func (s *Server) AutoComplete(req *AutoCompleteRequest, res *AutoCompleteReply)
 	return nil
 }
 
+// InvalidateRequest lists the import paths of packages that changed
+// outside of the editor, e.g. after "go generate". If ImportPaths is
+// empty, all packages are invalidated.
+type InvalidateRequest struct {
+	ImportPaths []string
+}
+type InvalidateReply struct{}
+
+// Invalidate updates the index of importable packages for the packages
+// in ImportPaths. Without import paths, it drops everything the daemon
+// caches across requests: the whole index, the tokens of buffers and the
+// decoded config files. Packages themselves are loaded afresh for each
+// completion.
+func (s *Server) Invalidate(req *InvalidateRequest, res *InvalidateReply) error {
+	if len(req.ImportPaths) == 0 {
+		if *g_debug {
+			log.Printf("Invalidating all packages\n")
+		}
+		suggest.ResetCaches()
+		return nil
+	}
+	if *g_debug {
+		log.Printf("Invalidating packages: %v\n", req.ImportPaths)
+	}
+	suggest.InvalidatePackages(req.ImportPaths)
+	return nil
+}
+
+// AcceptRequest reports the candidate the user accepted, by its name
+// and package path, for adaptive ranking.
+type AcceptRequest struct {
+	Name    string
+	PkgPath string
+}
+type AcceptReply struct{}
+
+func (s *Server) Accept(req *AcceptRequest, res *AcceptReply) error {
+	if *g_debug {
+		log.Printf("Accepted %s from %s\n", req.Name, req.PkgPath)
+	}
+	suggest.RecordAcceptance(req.Name, req.PkgPath)
+	return nil
+}
+
+type StatsRequest struct{}
+
+// StatsReply reports the requests served since the daemon started.
+// Packages are loaded afresh for each completion, so the cache figures
+// describe the index of importable packages. HeapAlloc is the memory
+// held by the daemon, in bytes.
+type StatsReply struct {
+	Requests       int
+	AverageLatency time.Duration
+	CacheHits      int
+	CacheMisses    int
+	CachedPackages int
+	HeapAlloc      uint64
+}
+
+// HitRate returns the fraction of index lookups answered from the cache.
+func (r *StatsReply) HitRate() float64 {
+	if n := r.CacheHits + r.CacheMisses; n > 0 {
+		return float64(r.CacheHits) / float64(n)
+	}
+	return 0
+}
+
+func (s *Server) Stats(req *StatsRequest, res *StatsReply) error {
+	stats.mu.Lock()
+	res.Requests = stats.requests
+	if stats.requests > 0 {
+		res.AverageLatency = stats.latency / time.Duration(stats.requests)
+	}
+	stats.mu.Unlock()
+
+	cs := suggest.ImportableCacheStats()
+	res.CacheHits, res.CacheMisses, res.CachedPackages = cs.Hits, cs.Misses, cs.Packages
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	res.HeapAlloc = m.HeapAlloc
+	return nil
+}
+
 type ExitRequest struct{}
 type ExitReply struct{}
 
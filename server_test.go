@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stamblerre/gocode/internal/suggest"
+)
+
+func TestRequestOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gocode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := `{"Builtin": true, "IgnoreCase": true, "Fast": true}`
+	if err := ioutil.WriteFile(filepath.Join(dir, suggest.ConfigFileName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// An explicit false wins over the config file once the request went
+	// through gob, which omits false values.
+	sent := AutoCompleteRequest{
+		Filename:  filepath.Join(dir, "p.go"),
+		Overrides: []string{"IgnoreCase", "Fast"},
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&sent); err != nil {
+		t.Fatal(err)
+	}
+	var req AutoCompleteRequest
+	if err := gob.NewDecoder(&buf).Decode(&req); err != nil {
+		t.Fatal(err)
+	}
+	cfg := req.config()
+	if !cfg.Builtin || cfg.IgnoreCase || cfg.Fast {
+		t.Errorf("got Builtin %v, IgnoreCase %v, Fast %v; want true, false, false", cfg.Builtin, cfg.IgnoreCase, cfg.Fast)
+	}
+}